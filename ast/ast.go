@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/moreal/monkey/token"
+	"math/big"
 	"strings"
 )
 
+// Node is an element of the syntax tree. Pos and End return the span of
+// source the node was parsed from; End is the position right after it.
 type Node interface {
 	TokenLiteral() string
+	Pos() token.Position
+	End() token.Position
 }
 
 type Statement interface {
@@ -34,6 +39,18 @@ func (p *Program) TokenLiteral() string {
 		return ""
 	}
 }
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
 
 type LetStatement struct {
 	Token token.Token
@@ -45,6 +62,12 @@ func (*LetStatement) statementNode() {}
 func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
+func (ls *LetStatement) Pos() token.Position {
+	return ls.Token.Start
+}
+func (ls *LetStatement) End() token.Position {
+	return ls.Value.End()
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -66,6 +89,12 @@ func (*ReturnStatement) statementNode() {}
 func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
+func (rs *ReturnStatement) Pos() token.Position {
+	return rs.Token.Start
+}
+func (rs *ReturnStatement) End() token.Position {
+	return rs.Value.End()
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -85,6 +114,12 @@ func (*Identifier) expressionNode() {}
 func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
+func (i *Identifier) Pos() token.Position {
+	return i.Token.Start
+}
+func (i *Identifier) End() token.Position {
+	return i.Token.End
+}
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -98,28 +133,80 @@ func (*ExpressionStatement) statementNode() {}
 func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
+func (es *ExpressionStatement) Pos() token.Position {
+	return es.Expression.Pos()
+}
+func (es *ExpressionStatement) End() token.Position {
+	return es.Expression.End()
+}
 func (es *ExpressionStatement) String() string {
-	var out bytes.Buffer
-
-	out.WriteString(es.Expression.String())
-	out.WriteString(token.SEMICOLON)
-
-	return out.String()
+	return es.Expression.String()
 }
 
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	// Big holds the value instead of Value when it does not fit in an int64.
+	Big *big.Int
 }
 
 func (*IntegerLiteral) expressionNode() {}
 func (i *IntegerLiteral) TokenLiteral() string {
 	return i.Token.Literal
 }
+func (i *IntegerLiteral) Pos() token.Position {
+	return i.Token.Start
+}
+func (i *IntegerLiteral) End() token.Position {
+	return i.Token.End
+}
 func (i *IntegerLiteral) String() string {
+	if i.Big != nil {
+		return i.Big.String()
+	}
 	return fmt.Sprintf("%d", i.Value)
 }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (*FloatLiteral) expressionNode() {}
+func (f *FloatLiteral) TokenLiteral() string {
+	return f.Token.Literal
+}
+func (f *FloatLiteral) Pos() token.Position {
+	return f.Token.Start
+}
+func (f *FloatLiteral) End() token.Position {
+	return f.Token.End
+}
+func (f *FloatLiteral) String() string {
+	return f.Token.Literal
+}
+
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (*StringLiteral) expressionNode() {}
+func (s *StringLiteral) TokenLiteral() string {
+	return s.Token.Literal
+}
+func (s *StringLiteral) Pos() token.Position {
+	return s.Token.Start
+}
+func (s *StringLiteral) End() token.Position {
+	return s.Token.End
+}
+func (s *StringLiteral) String() string {
+	return `"` + stringEscaper.Replace(s.Value) + `"`
+}
+
+var stringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
@@ -130,6 +217,12 @@ func (*PrefixExpression) expressionNode() {}
 func (p *PrefixExpression) TokenLiteral() string {
 	return p.Token.Literal
 }
+func (p *PrefixExpression) Pos() token.Position {
+	return p.Token.Start
+}
+func (p *PrefixExpression) End() token.Position {
+	return p.Right.End()
+}
 func (p *PrefixExpression) String() string {
 	return fmt.Sprintf("%s%s%s%s", token.LPAREN, p.Operator, p.Right.String(), token.RPAREN)
 }
@@ -145,6 +238,12 @@ func (*InfixExpression) expressionNode() {}
 func (i *InfixExpression) TokenLiteral() string {
 	return i.Token.Literal
 }
+func (i *InfixExpression) Pos() token.Position {
+	return i.Left.Pos()
+}
+func (i *InfixExpression) End() token.Position {
+	return i.Right.End()
+}
 func (i *InfixExpression) String() string {
 	return fmt.Sprintf("%s%s %s %s%s", token.LPAREN, i.Left.String(), i.Operator, i.Right.String(), token.RPAREN)
 }
@@ -158,10 +257,34 @@ func (*Boolean) expressionNode() {}
 func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
+func (b *Boolean) Pos() token.Position {
+	return b.Token.Start
+}
+func (b *Boolean) End() token.Position {
+	return b.Token.End
+}
 func (b *Boolean) String() string {
 	return fmt.Sprintf("%t", b.Value)
 }
 
+type Null struct {
+	Token token.Token
+}
+
+func (*Null) expressionNode() {}
+func (n *Null) TokenLiteral() string {
+	return n.Token.Literal
+}
+func (n *Null) Pos() token.Position {
+	return n.Token.Start
+}
+func (n *Null) End() token.Position {
+	return n.Token.End
+}
+func (*Null) String() string {
+	return "null"
+}
+
 type IfExpression struct {
 	Token       token.Token
 	Condition   Expression
@@ -173,6 +296,15 @@ func (*IfExpression) expressionNode() {}
 func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
+func (ie *IfExpression) Pos() token.Position {
+	return ie.Token.Start
+}
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	return ie.Consequence.End()
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -190,14 +322,21 @@ func (ie *IfExpression) String() string {
 }
 
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // {
 	Statements []Statement
+	Rbrace     token.Token
 }
 
 func (*BlockStatement) statementNode() {}
 func (bs *BlockStatement) TokenLiteral() string {
 	return bs.Token.Literal
 }
+func (bs *BlockStatement) Pos() token.Position {
+	return bs.Token.Start
+}
+func (bs *BlockStatement) End() token.Position {
+	return bs.Rbrace.End
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -208,9 +347,41 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
+// Parameter is a parameter of a function literal. It has a Default value
+// when it is optional, and is a Rest parameter, collecting the remaining
+// arguments into an array, when its name follows "...".
+type Parameter struct {
+	Token   token.Token // the name, or ... for a rest parameter
+	Name    *Identifier
+	Default Expression
+	Rest    bool
+}
+
+func (p *Parameter) TokenLiteral() string {
+	return p.Token.Literal
+}
+func (p *Parameter) Pos() token.Position {
+	return p.Token.Start
+}
+func (p *Parameter) End() token.Position {
+	if p.Default != nil {
+		return p.Default.End()
+	}
+	return p.Name.End()
+}
+func (p *Parameter) String() string {
+	switch {
+	case p.Rest:
+		return token.ELLIPSIS + p.Name.String()
+	case p.Default != nil:
+		return fmt.Sprintf("%s %s %s", p.Name.String(), token.ASSIGN, p.Default.String())
+	}
+	return p.Name.String()
+}
+
 type FunctionLiteral struct {
 	Token      token.Token
-	Parameters []*Identifier
+	Parameters []*Parameter
 	Body       *BlockStatement
 }
 
@@ -218,6 +389,12 @@ func (*FunctionLiteral) expressionNode() {}
 func (f *FunctionLiteral) TokenLiteral() string {
 	return f.Token.Literal
 }
+func (f *FunctionLiteral) Pos() token.Position {
+	return f.Token.Start
+}
+func (f *FunctionLiteral) End() token.Position {
+	return f.Body.End()
+}
 func (f *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -235,16 +412,26 @@ func (f *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// CallExpression calls Function with the positional Arguments followed by
+// the NamedArguments.
 type CallExpression struct {
-	Token     token.Token
-	Function  Expression
-	Arguments []Expression
+	Token          token.Token // (
+	Function       Expression
+	Arguments      []Expression
+	NamedArguments []*NamedArgument
+	Rparen         token.Token
 }
 
 func (*CallExpression) expressionNode() {}
 func (ce *CallExpression) TokenLiteral() string {
 	return ce.Token.Literal
 }
+func (ce *CallExpression) Pos() token.Position {
+	return ce.Function.Pos()
+}
+func (ce *CallExpression) End() token.Position {
+	return ce.Rparen.End
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -252,6 +439,9 @@ func (ce *CallExpression) String() string {
 	for _, argument := range ce.Arguments {
 		args = append(args, argument.String())
 	}
+	for _, argument := range ce.NamedArguments {
+		args = append(args, argument.String())
+	}
 
 	out.WriteString(ce.Function.String())
 	out.WriteString(token.LPAREN)
@@ -260,3 +450,113 @@ func (ce *CallExpression) String() string {
 
 	return out.String()
 }
+
+// NamedArgument is an argument of a call bound to the parameter called
+// Name rather than by its position.
+type NamedArgument struct {
+	Token token.Token // the name
+	Name  *Identifier
+	Value Expression
+}
+
+func (na *NamedArgument) TokenLiteral() string {
+	return na.Token.Literal
+}
+func (na *NamedArgument) Pos() token.Position {
+	return na.Token.Start
+}
+func (na *NamedArgument) End() token.Position {
+	return na.Value.End()
+}
+func (na *NamedArgument) String() string {
+	return na.Name.String() + token.COLON + " " + na.Value.String()
+}
+
+type ArrayLiteral struct {
+	Token    token.Token // [
+	Elements []Expression
+	Rbracket token.Token
+}
+
+func (*ArrayLiteral) expressionNode() {}
+func (al *ArrayLiteral) TokenLiteral() string {
+	return al.Token.Literal
+}
+func (al *ArrayLiteral) Pos() token.Position {
+	return al.Token.Start
+}
+func (al *ArrayLiteral) End() token.Position {
+	return al.Rbracket.End
+}
+func (al *ArrayLiteral) String() string {
+	var out bytes.Buffer
+
+	var elements []string
+	for _, element := range al.Elements {
+		elements = append(elements, element.String())
+	}
+
+	out.WriteString(token.LBRACKET)
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString(token.RBRACKET)
+
+	return out.String()
+}
+
+type IndexExpression struct {
+	Token    token.Token // [
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token
+}
+
+func (*IndexExpression) expressionNode() {}
+func (ie *IndexExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+func (ie *IndexExpression) Pos() token.Position {
+	return ie.Left.Pos()
+}
+func (ie *IndexExpression) End() token.Position {
+	return ie.Rbracket.End
+}
+func (ie *IndexExpression) String() string {
+	return fmt.Sprintf("%s%s%s%s%s%s", token.LPAREN, ie.Left.String(), token.LBRACKET, ie.Index.String(), token.RBRACKET, token.RPAREN)
+}
+
+type HashLiteral struct {
+	Token  token.Token // {
+	Pairs  []HashPair
+	Rbrace token.Token
+}
+
+// HashPair is a key-value pair of a HashLiteral, kept in source order.
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+func (*HashLiteral) expressionNode() {}
+func (hl *HashLiteral) TokenLiteral() string {
+	return hl.Token.Literal
+}
+func (hl *HashLiteral) Pos() token.Position {
+	return hl.Token.Start
+}
+func (hl *HashLiteral) End() token.Position {
+	return hl.Rbrace.End
+}
+func (hl *HashLiteral) String() string {
+	var out bytes.Buffer
+
+	var pairs []string
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+token.COLON+" "+pair.Value.String())
+	}
+
+	out.WriteString(token.LBRACE)
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString(token.RBRACE)
+
+	return out.String()
+}
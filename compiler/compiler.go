@@ -0,0 +1,722 @@
+package compiler
+
+import (
+	"fmt"
+	"github.com/moreal/monkey/ast"
+	"github.com/moreal/monkey/code"
+	"github.com/moreal/monkey/evaluator"
+	"github.com/moreal/monkey/object"
+	"github.com/moreal/monkey/token"
+	"sort"
+)
+
+// Bytecode is the compiled form of a program. GlobalNames holds the name of
+// each global slot, for reporting reads of undefined globals.
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Positions    map[int]token.Position
+	GlobalNames  []string
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	positions           map[int]token.Position
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// positions caches the positions of expressions starting with another
+	// expression, which Pos finds by walking down their left operands.
+	positions map[ast.Node]token.Position
+	// err is the first operand found not to fit in its instruction.
+	err error
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	"<":  code.OpLessThan,
+	"<=": code.OpLessThanOrEqual,
+	">":  code.OpGreaterThan,
+	">=": code.OpGreaterThanOrEqual,
+	"&&": code.OpAnd,
+	"||": code.OpOr,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShiftLeft,
+	">>": code.OpShiftRight,
+}
+
+// shortCircuitJumps are the jumps skipping the right operand of the logical
+// operators when the left one decides their value.
+var shortCircuitJumps = map[string]code.Opcode{
+	"&&": code.OpJumpIfFalse,
+	"||": code.OpJumpIfTrue,
+}
+
+var prefixOperators = map[string]code.Opcode{
+	"-": code.OpMinus,
+	"!": code.OpBang,
+	"~": code.OpBitNot,
+}
+
+func New() *Compiler {
+	symbolTable := NewSymbolTable()
+	for i, builtin := range evaluator.Builtins() {
+		symbolTable.DefineBuiltin(i, builtin.Name)
+	}
+
+	return NewWithState(symbolTable, nil)
+}
+
+// NewWithState returns a compiler which continues the compilation of
+// previous programs, whose global symbol table and constants it extends.
+// Their globals keep their slots, so the bytecode can run on the globals
+// left by the previous programs, as in the REPL.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	return &Compiler{
+		constants:   constants,
+		symbolTable: s,
+		scopes:      []CompilationScope{{positions: make(map[int]token.Position)}},
+		positions:   make(map[ast.Node]token.Position),
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, name := range letNames(node, nil) {
+			c.symbolTable.Define(name)
+		}
+		if err := c.compileStatements(node.Statements); err != nil {
+			return err
+		}
+		return c.err
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	case *ast.LetStatement:
+		return c.compileLetStatement(node)
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.BlockStatement:
+		return c.compileStatements(node.Statements)
+	case *ast.Identifier:
+		symbols := c.symbolTable.ResolveAll(node.Value)
+		if len(symbols) == 0 {
+			// The global may still be defined before this code runs.
+			symbols = append(symbols, c.symbolTable.global().Define(node.Value))
+		}
+		c.loadSymbols(node, symbols)
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			// The VM rejects big literals with checked arithmetic.
+			c.emitAt(node, code.OpConstant, c.addConstant(&object.BigInteger{Value: node.Big}))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+		}
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.Null:
+		c.emit(code.OpNull)
+	case *ast.PrefixExpression:
+		op, ok := prefixOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emitAt(node, op)
+	case *ast.InfixExpression:
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		jumpPos := -1
+		if jump, ok := shortCircuitJumps[node.Operator]; ok {
+			jumpPos = c.emit(jump, 9999)
+		}
+
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emitAt(node, op)
+
+		if jumpPos >= 0 {
+			c.changeOperand(jumpPos, len(c.currentInstructions()))
+		}
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, arg := range node.Arguments {
+			if err := c.Compile(arg); err != nil {
+				return err
+			}
+		}
+		if len(node.NamedArguments) == 0 {
+			c.emitAt(node, code.OpCall, len(node.Arguments))
+			return nil
+		}
+		return c.compileNamedCall(node)
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			if err := c.Compile(element); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			// As in Eval, a key is rejected before its value is evaluated.
+			c.emitAt(node, code.OpHashKey)
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emitAt(node, code.OpHash, len(node.Pairs)*2)
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emitAt(node, code.OpIndex)
+	default:
+		return fmt.Errorf("%s: cannot compile %T", node.Pos(), node)
+	}
+
+	return nil
+}
+
+// compileStatements compiles a sequence of statements whose value is the
+// value of the last one, as it is for Eval. A trailing let statement
+// therefore leaves its value like an expression statement does.
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	for _, statement := range statements {
+		if err := c.Compile(statement); err != nil {
+			return err
+		}
+	}
+
+	if len(statements) > 0 {
+		if let, ok := statements[len(statements)-1].(*ast.LetStatement); ok {
+			symbol, _ := c.symbolTable.Resolve(let.Name.Value)
+			c.loadSymbol(let.Name, symbol)
+			c.emit(code.OpPop)
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileLetStatement(node *ast.LetStatement) error {
+	// Functions are defined before their body is compiled so that they
+	// can refer to themselves. Local ones capture the variable they are
+	// bound to, which is set by the time they are called.
+	var symbol Symbol
+	fn, isFunction := node.Value.(*ast.FunctionLiteral)
+	if isFunction {
+		symbol = c.symbolTable.Define(node.Name.Value)
+		if err := c.compileFunctionLiteral(fn); err != nil {
+			return err
+		}
+	} else {
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		symbol = c.symbolTable.Define(node.Name.Value)
+	}
+
+	if symbol.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// compileBlockValue compiles a block which leaves its value on the stack.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else if len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+// compileFunctionLiteral compiles a function to a closure capturing the
+// free variables it uses. Free variables are captured by reference when
+// the closure is created.
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	var parameters, names []string
+	numRequired := 0
+	variadic := false
+	for _, param := range node.Parameters {
+		c.symbolTable.DefineParameter(param.Name.Value)
+		parameters = append(parameters, param.String())
+
+		if param.Rest {
+			variadic = true
+			continue
+		}
+		names = append(names, param.Name.Value)
+		if param.Default == nil {
+			numRequired++
+		}
+	}
+
+	// Let statements define variables of the whole function, even before
+	// they run, as they do in the environment of the call for Eval.
+	var lets []string
+	for _, param := range node.Parameters {
+		if param.Default != nil {
+			lets = letNames(param.Default, lets)
+		}
+	}
+	for _, name := range letNames(node.Body, lets) {
+		c.symbolTable.Define(name)
+	}
+
+	for i, param := range node.Parameters {
+		if param.Default != nil {
+			if err := c.compileDefaultValue(i, node.Parameters); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.names
+	captured := c.symbolTable.captured
+	positions := c.scopes[c.scopeIndex].positions
+	instructions := c.leaveScope()
+
+	freeVariables := make([]object.FreeVariable, len(freeSymbols))
+	for i, s := range freeSymbols {
+		freeVariables[i] = object.FreeVariable{Name: s.Name, Local: s.Scope == LocalScope, Index: s.Index}
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:   instructions,
+		NumLocals:      numLocals,
+		NumParameters:  len(names),
+		NumRequired:    numRequired,
+		Variadic:       variadic,
+		Parameters:     parameters,
+		ParameterNames: names,
+		LocalNames:     localNames,
+		FreeVariables:  freeVariables,
+		Captured:       captured,
+		Positions:      positions,
+	}
+	c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+
+	return nil
+}
+
+// compileNamedCall finishes compiling a call with named arguments, once
+// the function and the positional arguments are on the stack. The names
+// are passed as a constant array of strings, in the order of the values
+// following the positional arguments.
+func (c *Compiler) compileNamedCall(node *ast.CallExpression) error {
+	names := &object.Array{}
+	for _, arg := range node.NamedArguments {
+		if err := c.Compile(arg.Value); err != nil {
+			return err
+		}
+		names.Elements = append(names.Elements, &object.String{Value: arg.Name.Value})
+	}
+
+	c.emitAt(node, code.OpCallNamed, len(node.Arguments), c.addConstant(names))
+	return nil
+}
+
+// compileDefaultValue compiles the code which sets the parameter at index
+// to its default value when the call passed no argument for it. As in
+// Eval, the parameters from this one on are not bound yet, so their names
+// refer to the enclosing scopes in the default value.
+func (c *Compiler) compileDefaultValue(index int, parameters []*ast.Parameter) error {
+	hidden := make(map[string]Symbol)
+	for _, param := range parameters[index:] {
+		if symbol, ok := c.symbolTable.store[param.Name.Value]; ok {
+			hidden[param.Name.Value] = symbol
+			delete(c.symbolTable.store, param.Name.Value)
+		}
+	}
+
+	jumpPos := c.emit(code.OpJumpIfSet, index, 9999)
+	if err := c.Compile(parameters[index].Default); err != nil {
+		return err
+	}
+	c.emit(code.OpSetLocal, index)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	for name, symbol := range hidden {
+		c.symbolTable.store[name] = symbol
+	}
+
+	return nil
+}
+
+// setJumps are the instructions jumping when the symbol of a scope is set.
+var setJumps = map[SymbolScope]code.Opcode{
+	LocalScope:  code.OpJumpIfSet,
+	FreeScope:   code.OpJumpIfFreeSet,
+	GlobalScope: code.OpJumpIfGlobalSet,
+}
+
+// loadSymbols loads the first of symbols which is set when the code runs,
+// or the last one, as returned by ResolveAll.
+func (c *Compiler) loadSymbols(node ast.Node, symbols []Symbol) {
+	last := len(symbols) - 1
+	if last == 0 {
+		c.loadSymbol(node, symbols[0])
+		return
+	}
+
+	jumps := make([]int, last)
+	for i, s := range symbols[:last] {
+		jumps[i] = c.emit(setJumps[s.Scope], s.Index, 9999)
+	}
+	c.loadSymbol(node, symbols[last])
+
+	var ends []int
+	for i, s := range symbols[:last] {
+		ends = append(ends, c.emit(code.OpJump, 9999))
+		c.changeOperand(jumps[i], len(c.currentInstructions()))
+		c.loadSymbol(node, s)
+	}
+	for _, end := range ends {
+		c.changeOperand(end, len(c.currentInstructions()))
+	}
+}
+
+func (c *Compiler) loadSymbol(node ast.Node, s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emitAt(node, code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emitAt(node, code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emitAt(node, code.OpGetFree, s.Index)
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	global := c.symbolTable.global()
+
+	var globals []Symbol
+	for _, symbol := range global.store {
+		if symbol.Scope == GlobalScope {
+			globals = append(globals, symbol)
+		}
+	}
+	sort.Slice(globals, func(i, j int) bool { return globals[i].Index < globals[j].Index })
+
+	names := make([]string, len(globals))
+	for i, symbol := range globals {
+		names[i] = symbol.Name
+	}
+
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Positions:    c.scopes[c.scopeIndex].positions,
+		GlobalNames:  names,
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+// emitAt emits an instruction which may raise a runtime error, recording
+// the position of node for it.
+func (c *Compiler) emitAt(node ast.Node, op code.Opcode, operands ...int) int {
+	pos := c.emit(op, operands...)
+	c.scopes[c.scopeIndex].positions[pos] = c.position(node)
+	return pos
+}
+
+// position returns node.Pos() in constant time for the operands of the
+// instructions emitted so far, so that long operator chains compile in
+// linear time.
+func (c *Compiler) position(node ast.Node) token.Position {
+	var left ast.Node
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		left = node.Left
+	case *ast.CallExpression:
+		left = node.Function
+	case *ast.IndexExpression:
+		left = node.Left
+	default:
+		return node.Pos()
+	}
+
+	pos, ok := c.positions[node]
+	if !ok {
+		pos = c.position(left)
+		c.positions[node] = pos
+	}
+	return pos
+}
+
+// checkOperands records an error when an operand does not fit in the
+// width the instruction reserves for it, which only happens for programs
+// with too many constants, globals, locals or instructions.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, err := code.Lookup(byte(op))
+	if err != nil || c.err != nil {
+		return
+	}
+
+	for i, operand := range operands {
+		if limit := 1 << (8 * def.OperandWidths[i]); operand >= limit {
+			c.err = fmt.Errorf("program too large: operand %d of %s exceeds %d", operand, def.Name, limit-1)
+			return
+		}
+	}
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// changeOperand replaces the last operand of the instruction at opPos,
+// which is the target of jumps.
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	def, _ := code.Lookup(byte(op))
+	operands, _ := code.ReadOperands(def, c.currentInstructions()[opPos+1:])
+	operands[len(operands)-1] = operand
+	c.checkOperands(op, operands)
+	newInstruction := code.Make(op, operands...)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{positions: make(map[int]token.Position)})
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
+// letNames appends the names defined by let statements in node to names,
+// leaving out those in function literals, which define their own.
+func letNames(node ast.Node, names []string) []string {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			names = letNames(s, names)
+		}
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			names = letNames(s, names)
+		}
+	case *ast.LetStatement:
+		names = letNames(node.Value, names)
+		names = append(names, node.Name.Value)
+	case *ast.ReturnStatement:
+		names = letNames(node.Value, names)
+	case *ast.ExpressionStatement:
+		names = letNames(node.Expression, names)
+	case *ast.PrefixExpression:
+		names = letNames(node.Right, names)
+	case *ast.InfixExpression:
+		names = letNames(node.Left, names)
+		names = letNames(node.Right, names)
+	case *ast.IfExpression:
+		names = letNames(node.Condition, names)
+		names = letNames(node.Consequence, names)
+		if node.Alternative != nil {
+			names = letNames(node.Alternative, names)
+		}
+	case *ast.CallExpression:
+		names = letNames(node.Function, names)
+		for _, arg := range node.Arguments {
+			names = letNames(arg, names)
+		}
+		for _, arg := range node.NamedArguments {
+			names = letNames(arg.Value, names)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			names = letNames(el, names)
+		}
+	case *ast.IndexExpression:
+		names = letNames(node.Left, names)
+		names = letNames(node.Index, names)
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			names = letNames(pair.Key, names)
+			names = letNames(pair.Value, names)
+		}
+	}
+	return names
+}
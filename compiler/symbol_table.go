@@ -0,0 +1,152 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	BuiltinScope SymbolScope = "BUILTIN"
+	// FreeScope symbols are locals of an enclosing function, captured by
+	// the closure when it is created.
+	FreeScope SymbolScope = "FREE"
+)
+
+// Symbol is a storage slot for a name. Parameter is set for the slots of
+// parameters, which hold a value as soon as the function runs, while other
+// variables are only set once their let statement ran.
+type Symbol struct {
+	Name      string
+	Scope     SymbolScope
+	Index     int
+	Parameter bool
+}
+
+// SymbolTable maps names to storage slots. Function bodies get their own
+// table enclosing the table of the surrounding code.
+type SymbolTable struct {
+	Outer *SymbolTable
+	// FreeSymbols are the symbols of the enclosing table captured by this
+	// one, in the order of their FreeScope indexes.
+	FreeSymbols []Symbol
+
+	store          map[string]Symbol
+	builtins       map[string]Symbol
+	free           map[Symbol]Symbol
+	numDefinitions int
+	// captured is set once an enclosed table captures one of the locals.
+	captured bool
+	// names holds the name of each definition, by index.
+	names []string
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		store:    make(map[string]Symbol),
+		builtins: make(map[string]Symbol),
+		free:     make(map[Symbol]Symbol),
+	}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define returns the symbol for name in this table, allocating a new slot
+// unless the name is already defined here.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
+	if s.Outer != nil {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+	s.names = append(s.names, name)
+	return symbol
+}
+
+// DefineParameter defines name as a parameter of the function.
+func (s *SymbolTable) DefineParameter(name string) Symbol {
+	symbol := s.Define(name)
+	symbol.Parameter = true
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineBuiltin defines a builtin, which is only used when name is not
+// defined otherwise or not set yet.
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.builtins[name] = symbol
+	return symbol
+}
+
+// Resolve returns the innermost symbol name refers to.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbols := s.ResolveAll(name)
+	if len(symbols) == 0 {
+		return Symbol{}, false
+	}
+	return symbols[0], true
+}
+
+// ResolveAll returns the symbols name may refer to, innermost first. As
+// Eval looks names up when the code runs, the first one which is set by
+// then is the one meant, and the last one is always meant otherwise. The
+// symbols local to an enclosing function are defined as free symbols of
+// every table between it and this one.
+func (s *SymbolTable) ResolveAll(name string) []Symbol {
+	var symbols []Symbol
+	if symbol, ok := s.store[name]; ok {
+		symbols = append(symbols, symbol)
+		if symbol.Parameter {
+			return symbols
+		}
+	}
+
+	if s.Outer == nil {
+		if builtin, ok := s.builtins[name]; ok {
+			symbols = append(symbols, builtin)
+		}
+		return symbols
+	}
+
+	for _, symbol := range s.Outer.ResolveAll(name) {
+		switch symbol.Scope {
+		case LocalScope:
+			s.Outer.captured = true
+			symbol = s.defineFree(symbol)
+		case FreeScope:
+			symbol = s.defineFree(symbol)
+		}
+		symbols = append(symbols, symbol)
+	}
+
+	return symbols
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	if symbol, ok := s.free[original]; ok {
+		return symbol
+	}
+
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope, Parameter: original.Parameter}
+	s.free[original] = symbol
+	return symbol
+}
+
+// global returns the outermost table.
+func (s *SymbolTable) global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
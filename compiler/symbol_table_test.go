@@ -0,0 +1,106 @@
+package compiler
+
+import "testing"
+
+func TestDefine(t *testing.T) {
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: GlobalScope, Index: 1},
+		"c": {Name: "c", Scope: LocalScope, Index: 0},
+		"d": {Name: "d", Scope: LocalScope, Index: 1},
+	}
+
+	global := NewSymbolTable()
+	if a := global.Define("a"); a != expected["a"] {
+		t.Errorf("Expected a=%+v but %+v", expected["a"], a)
+	}
+	if b := global.Define("b"); b != expected["b"] {
+		t.Errorf("Expected b=%+v but %+v", expected["b"], b)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	if c := local.Define("c"); c != expected["c"] {
+		t.Errorf("Expected c=%+v but %+v", expected["c"], c)
+	}
+	if d := local.Define("d"); d != expected["d"] {
+		t.Errorf("Expected d=%+v but %+v", expected["d"], d)
+	}
+}
+
+func TestRedefine(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+
+	a := global.Define("a")
+	if again := global.Define("a"); again != a {
+		t.Errorf("Expected redefining a to keep %+v but %+v", a, again)
+	}
+
+	expected := Symbol{Name: "len", Scope: GlobalScope, Index: 1}
+	if len := global.Define("len"); len != expected {
+		t.Errorf("Expected defining len to shadow the builtin with %+v but %+v", expected, len)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.DefineBuiltin(0, "len")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "len", Scope: BuiltinScope, Index: 0},
+		{Name: "b", Scope: LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := local.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("Expected %s to resolve to %+v but %+v", sym.Name, sym, result)
+		}
+	}
+
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("Expected the local b not to be resolvable globally")
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	first := NewEnclosedSymbolTable(global)
+	first.Define("b")
+
+	second := NewEnclosedSymbolTable(first)
+	second.Define("c")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: FreeScope, Index: 0},
+		{Name: "c", Scope: LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := second.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("Expected %s to resolve to %+v but %+v", sym.Name, sym, result)
+		}
+	}
+
+	expectedFree := []Symbol{{Name: "b", Scope: LocalScope, Index: 0}}
+	if len(second.FreeSymbols) != len(expectedFree) || second.FreeSymbols[0] != expectedFree[0] {
+		t.Errorf("Expected free symbols %+v but %+v", expectedFree, second.FreeSymbols)
+	}
+}
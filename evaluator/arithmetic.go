@@ -0,0 +1,130 @@
+package evaluator
+
+import (
+	"github.com/moreal/monkey/object"
+	"math"
+	"math/big"
+)
+
+// maxIntegerBits bounds the size of big integers, so that programs like
+// 2 ** 10000000000 fail with an error instead of exhausting the memory.
+const maxIntegerBits = 1 << 24
+
+// IntegerArithmetic applies the arithmetic operator to left and right,
+// reporting false when the result overflowed and wrapped around, in which
+// case EvalInfix computes it as a big integer. Division by zero, negative
+// exponents and negative shift counts must be ruled out by the caller.
+func IntegerArithmetic(operator string, left, right int64) (int64, bool) {
+	switch operator {
+	case "+":
+		result := left + right
+		return result, (result > left) == (right > 0)
+	case "-":
+		result := left - right
+		return result, (result < left) == (right > 0)
+	case "*":
+		if left == 0 || right == 0 {
+			return 0, true
+		}
+		result := left * right
+		// MinInt64 / -1 wraps around to MinInt64 itself.
+		return result, result/right == left && !(right == -1 && left == math.MinInt64)
+	case "/":
+		return left / right, !(left == math.MinInt64 && right == -1)
+	case "%":
+		// MinInt64 % -1 is 0, which Go computes without overflowing.
+		return left % right, true
+	case "**":
+		return integerPower(left, right)
+	case "&":
+		return left & right, true
+	case "|":
+		return left | right, true
+	case "^":
+		return left ^ right, true
+	case "<<":
+		if right >= 64 {
+			return 0, left == 0
+		}
+		result := left << right
+		return result, result>>right == left
+	case ">>":
+		return left >> right, true
+	}
+
+	return 0, false
+}
+
+// integerPower computes base ** exponent by repeated squaring, reporting
+// false when an intermediate result overflows.
+func integerPower(base, exponent int64) (int64, bool) {
+	result := int64(1)
+	for {
+		if exponent&1 == 1 {
+			var ok bool
+			if result, ok = IntegerArithmetic("*", result, base); !ok {
+				return 0, false
+			}
+		}
+		exponent >>= 1
+		if exponent == 0 {
+			return result, true
+		}
+
+		var ok bool
+		if base, ok = IntegerArithmetic("*", base, base); !ok {
+			return 0, false
+		}
+	}
+}
+
+// sign returns -1, 0 or +1 depending on the sign of the integer obj.
+func sign(obj object.Object) int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		switch {
+		case obj.Value < 0:
+			return -1
+		case obj.Value > 0:
+			return 1
+		}
+	case *object.BigInteger:
+		return obj.Value.Sign()
+	}
+
+	return 0
+}
+
+func toBigInt(obj object.Object) *big.Int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value)
+	case *object.BigInteger:
+		return obj.Value
+	}
+
+	return nil
+}
+
+func isNumber(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Integer, *object.BigInteger, *object.Float:
+		return true
+	}
+	return false
+}
+
+// toFloat converts a number to the nearest float.
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInteger:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *object.Float:
+		return obj.Value
+	}
+
+	return 0
+}
@@ -0,0 +1,197 @@
+package evaluator
+
+import (
+	"fmt"
+	"github.com/moreal/monkey/object"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// Output is where puts writes, standard output unless the host redirects
+// it, as the REPL does.
+var Output io.Writer = os.Stdout
+
+// builtins are consulted when an identifier is not found in the
+// environment, so user definitions shadow them. They are kept in
+// registration order, which compiled code refers to them by.
+var builtins []*object.Builtin
+
+func init() {
+	RegisterBuiltin("len", builtinLen, "value")
+	RegisterBuiltin("puts", builtinPuts)
+	RegisterBuiltin("first", builtinFirst, "value")
+	RegisterBuiltin("last", builtinLast, "value")
+	RegisterBuiltin("rest", builtinRest, "value")
+	RegisterBuiltin("push", builtinPush, "array", "element")
+	RegisterBuiltin("type", builtinType, "value")
+}
+
+// RegisterBuiltin makes fn callable as name from every program evaluated
+// afterwards, replacing any builtin with the same name. Calls may pass the
+// arguments by the names of parameters, if given.
+func RegisterBuiltin(name string, fn object.BuiltinFunction, parameters ...string) {
+	builtin := &object.Builtin{Name: name, Fn: fn, Parameters: parameters}
+
+	for i, b := range builtins {
+		if b.Name == name {
+			builtins[i] = builtin
+			return
+		}
+	}
+
+	builtins = append(builtins, builtin)
+}
+
+// Builtins returns the registered builtins. A builtin keeps its index even
+// when it is replaced.
+func Builtins() []*object.Builtin {
+	return builtins
+}
+
+func lookupBuiltin(name string) (*object.Builtin, bool) {
+	for _, builtin := range builtins {
+		if builtin.Name == name {
+			return builtin, true
+		}
+	}
+
+	return nil, false
+}
+
+func builtinLen(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return wrongNumberOfArguments(1, len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *object.String:
+		return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+	case *object.Array:
+		return &object.Integer{Value: int64(len(arg.Elements))}
+	case *object.Hash:
+		return &object.Integer{Value: int64(len(arg.Pairs))}
+	default:
+		return newError("argument to `len` not supported, got %s", arg.Type())
+	}
+}
+
+func builtinPuts(args ...object.Object) object.Object {
+	for _, arg := range args {
+		fmt.Fprintln(Output, arg.Inspect())
+	}
+
+	return NULL
+}
+
+func builtinFirst(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return wrongNumberOfArguments(1, len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *object.String:
+		if arg.Value == "" {
+			return NULL
+		}
+		r, _ := utf8.DecodeRuneInString(arg.Value)
+		return &object.String{Value: string(r)}
+	case *object.Array:
+		if len(arg.Elements) == 0 {
+			return NULL
+		}
+		return arg.Elements[0]
+	default:
+		return newError("argument to `first` not supported, got %s", arg.Type())
+	}
+}
+
+func builtinLast(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return wrongNumberOfArguments(1, len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *object.String:
+		if arg.Value == "" {
+			return NULL
+		}
+		r, _ := utf8.DecodeLastRuneInString(arg.Value)
+		return &object.String{Value: string(r)}
+	case *object.Array:
+		if len(arg.Elements) == 0 {
+			return NULL
+		}
+		return arg.Elements[len(arg.Elements)-1]
+	default:
+		return newError("argument to `last` not supported, got %s", arg.Type())
+	}
+}
+
+func builtinRest(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return wrongNumberOfArguments(1, len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *object.String:
+		if arg.Value == "" {
+			return NULL
+		}
+		_, size := utf8.DecodeRuneInString(arg.Value)
+		return &object.String{Value: arg.Value[size:]}
+	case *object.Array:
+		if len(arg.Elements) == 0 {
+			return NULL
+		}
+		elements := make([]object.Object, len(arg.Elements)-1)
+		copy(elements, arg.Elements[1:])
+		return &object.Array{Elements: elements}
+	default:
+		return newError("argument to `rest` not supported, got %s", arg.Type())
+	}
+}
+
+// builtinPush returns a new array with the element appended, leaving the
+// original array untouched.
+func builtinPush(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return wrongNumberOfArguments(2, len(args))
+	}
+
+	array, ok := args[0].(*object.Array)
+	if !ok {
+		return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+	}
+
+	elements := make([]object.Object, len(array.Elements)+1)
+	copy(elements, array.Elements)
+	elements[len(array.Elements)] = args[1]
+
+	return &object.Array{Elements: elements}
+}
+
+func builtinType(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return wrongNumberOfArguments(1, len(args))
+	}
+
+	return &object.String{Value: string(args[0].Type())}
+}
+
+func wrongNumberOfArguments(want, got int) *object.Error {
+	return ArityError(want, want, got)
+}
+
+// ArityError is the error of calling a function which takes from min to
+// max arguments, or at least min when max is negative, with got arguments.
+func ArityError(min, max, got int) *object.Error {
+	switch {
+	case min == max:
+		return newError("wrong number of arguments: want %d, got %d", min, got)
+	case max < 0:
+		return newError("wrong number of arguments: want at least %d, got %d", min, got)
+	default:
+		return newError("wrong number of arguments: want %d to %d, got %d", min, max, got)
+	}
+}
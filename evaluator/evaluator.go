@@ -4,14 +4,60 @@ import (
 	"fmt"
 	"github.com/moreal/monkey/ast"
 	"github.com/moreal/monkey/object"
+	"math"
+	"math/big"
 )
 
 var (
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+	NULL  = &object.Null{}
 )
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+const (
+	// MaxCallDepth is the number of nested function calls after which a
+	// call fails with a stack overflow error.
+	MaxCallDepth = 1024
+	// MaxEvalDepth bounds the nesting of evaluations so that deeply nested
+	// expressions in recursive functions cannot exhaust the host stack.
+	MaxEvalDepth = 100000
+)
+
+// Eval evaluates node in env. It never returns nil: nodes without a value
+// evaluate to NULL. Runtime errors are returned as *object.Error located at
+// the innermost node which raised them. Eval never panics: a failure of
+// the evaluator itself is returned as an error.
+func Eval(node ast.Node, env *object.Environment) (result object.Object) {
+	depth := env.Enter()
+	if depth == 1 {
+		// Invalid operations are reported as errors by the code performing
+		// them, so a panic is a bug of the evaluator. It must not bring down
+		// the host anyway, so the outermost evaluation recovers from it.
+		defer func() {
+			if r := recover(); r != nil {
+				env.Reset()
+				err := newError("internal error: %v", r)
+				err.Pos = node.Pos()
+				result = err
+			}
+		}()
+	}
+
+	if depth > MaxEvalDepth {
+		result = newError("stack overflow")
+	} else {
+		result = eval(node, env)
+	}
+	env.Leave()
+
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node.Statements, env)
@@ -23,19 +69,22 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return right
 		}
 
-		return evalPrefixExpression(node.Operator, right)
+		return evalPrefixExpression(node.Operator, right, env.CheckedArithmetic())
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
+		if shortCircuits(node.Operator, left) {
+			return left
+		}
 
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node.Operator, left, right, env.CheckedArithmetic())
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
@@ -46,6 +95,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.Identifier:
 		if val, ok := env.Get(node.Value); ok {
 			return val
+		} else if builtin, ok := lookupBuiltin(node.Value); ok {
+			return builtin
 		} else {
 			return newError("identifier not found: %s", node.Value)
 		}
@@ -64,7 +115,17 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		var names []string
+		var named []object.Object
+		for _, arg := range node.NamedArguments {
+			value := Eval(arg.Value, env)
+			if isError(value) {
+				return value
+			}
+			names = append(names, arg.Name.Value)
+			named = append(named, value)
+		}
+		return applyFunction(env, function, args, names, named)
 	case *ast.BlockStatement:
 		return evalBlockStatements(node, env)
 	case *ast.ReturnStatement:
@@ -77,9 +138,41 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			if env.CheckedArithmetic() {
+				return LiteralOverflow(node.Big)
+			}
+			return &object.BigInteger{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
+	case *ast.ArrayLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &object.Array{Elements: elements}
+	case *ast.HashLiteral:
+		return evalHashLiteral(node, env)
+	case *ast.IndexExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index
+		}
+
+		return evalIndexExpression(left, index)
 	case *ast.Boolean:
 		return evalBoolean(node)
+	case *ast.Null:
+		return NULL
 	}
 
 	return &object.Error{Message: "Not supported evaluation target"}
@@ -100,29 +193,186 @@ func evalExpressions(arguments []ast.Expression, env *object.Environment) []obje
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
-	function, ok := fn.(*object.Function)
-	if !ok {
+// applyFunction calls fn with the positional args and the arguments named
+// names with values named, from a call evaluated in env.
+func applyFunction(
+	env *object.Environment,
+	fn object.Object,
+	args []object.Object,
+	names []string,
+	named []object.Object,
+) object.Object {
+	switch function := fn.(type) {
+	case *object.Function:
+		min, max := arity(function.Parameters)
+		args, err := BindArguments(parameterNames(function.Parameters), min, max, args, names, named)
+		if err != nil {
+			return err
+		}
+		if env.CallDepth() >= MaxCallDepth {
+			return newError("stack overflow")
+		}
+
+		extendedEnv, result := extendFunctionEnv(function, args, env)
+		if result != nil {
+			return unwrapReturnValue(result)
+		}
+		evaluated := Eval(function.Body, extendedEnv)
+
+		return unwrapReturnValue(evaluated)
+	case *object.Builtin:
+		if len(names) > 0 {
+			var err *object.Error
+			args, err = BindBuiltinArguments(function, args, names, named)
+			if err != nil {
+				return err
+			}
+		}
+		return callBuiltin(function, args)
+	default:
 		return newError("not a function: %s", fn.Type())
 	}
+}
 
-	extendedEnv := extendFunctionEnv(function, args)
-	evaluated := Eval(function.Body, extendedEnv)
+// callBuiltin calls builtin, turning a panic of the host function into an
+// error located at the call.
+func callBuiltin(builtin *object.Builtin, args []object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
 
-	return unwrapReturnValue(evaluated)
+	if result = builtin.Fn(args...); result == nil {
+		return NULL
+	}
+	return result
 }
 
+// arity returns the minimum and maximum number of arguments a function
+// with parameters accepts. max is -1 when it has a rest parameter.
+func arity(parameters []*ast.Parameter) (min, max int) {
+	for _, param := range parameters {
+		switch {
+		case param.Rest:
+			return min, -1
+		case param.Default == nil:
+			min++
+		}
+		max++
+	}
+
+	return min, max
+}
+
+// parameterNames returns the names of parameters which arguments can be
+// bound to by name, leaving out the rest parameter.
+func parameterNames(parameters []*ast.Parameter) []string {
+	var names []string
+	for _, param := range parameters {
+		if !param.Rest {
+			names = append(names, param.Name.Value)
+		}
+	}
+
+	return names
+}
+
+// BindArguments checks the arguments of a call to a function taking from
+// min to max arguments, as in ArityError, and returns them in the order of
+// the parameters: the positional args, then the values named, each at the
+// position of its name from names in parameters. Parameters which got no
+// argument are left nil, and must be optional.
+func BindArguments(
+	parameters []string,
+	min, max int,
+	args []object.Object,
+	names []string,
+	named []object.Object,
+) ([]object.Object, *object.Error) {
+	if len(args) < min && len(names) == 0 || max >= 0 && len(args) > max {
+		return nil, ArityError(min, max, len(args)+len(names))
+	}
+	if len(names) == 0 {
+		return args, nil
+	}
+
+	bound := make([]object.Object, len(args))
+	copy(bound, args)
+
+	for i, name := range names {
+		index := -1
+		for j, param := range parameters {
+			if param == name {
+				index = j
+				break
+			}
+		}
+
+		switch {
+		case index < 0:
+			return nil, newError("no parameter named %s", name)
+		case index < len(bound) && bound[index] != nil:
+			return nil, newError("multiple values for parameter %s", name)
+		}
+
+		for len(bound) <= index {
+			bound = append(bound, nil)
+		}
+		bound[index] = named[i]
+	}
+
+	for i := 0; i < min; i++ {
+		if i >= len(bound) || bound[i] == nil {
+			return nil, newError("missing argument for parameter %s", parameters[i])
+		}
+	}
+
+	return bound, nil
+}
+
+// BindBuiltinArguments binds the arguments of a call to builtin with named
+// arguments. Every parameter the builtin declares must then get one.
+func BindBuiltinArguments(
+	builtin *object.Builtin,
+	args []object.Object,
+	names []string,
+	named []object.Object,
+) ([]object.Object, *object.Error) {
+	return BindArguments(builtin.Parameters, len(builtin.Parameters), -1, args, names, named)
+}
+
+// extendFunctionEnv binds the parameters of function to args. Default
+// values of the parameters which did not get an argument are evaluated in
+// order, seeing the parameters before them. When one of them fails or
+// returns, its result is returned instead of an environment.
 func extendFunctionEnv(
 	function *object.Function,
 	args []object.Object,
-) *object.Environment {
-	env := object.NewEnclosedEnvironment(function.Env)
+	caller *object.Environment,
+) (*object.Environment, object.Object) {
+	env := object.NewCallEnvironment(function.Env, caller)
 
 	for paramIdx, param := range function.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		switch {
+		case param.Rest:
+			rest := []object.Object{}
+			if paramIdx < len(args) {
+				rest = append(rest, args[paramIdx:]...)
+			}
+			env.Set(param.Name.Value, &object.Array{Elements: rest})
+		case paramIdx < len(args) && args[paramIdx] != nil:
+			env.Set(param.Name.Value, args[paramIdx])
+		default:
+			value := Eval(param.Default, env)
+			if rt := value.Type(); rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return nil, value
+			}
+			env.Set(param.Name.Value, value)
+		}
 	}
 
-	return env
+	return env, nil
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -134,8 +384,7 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func evalProgram(statements []ast.Statement, env *object.Environment) object.Object {
-	var result object.Object
-
+	var result object.Object = NULL
 	for _, statement := range statements {
 		result = Eval(statement, env)
 
@@ -151,28 +400,30 @@ func evalProgram(statements []ast.Statement, env *object.Environment) object.Obj
 }
 
 func evalBlockStatements(blockStatement *ast.BlockStatement, env *object.Environment) object.Object {
-	var result object.Object
+	var result object.Object = NULL
 
 	for _, statement := range blockStatement.Statements {
 		result = Eval(statement, env)
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				return result
-			}
+		rt := result.Type()
+		if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			return result
 		}
 	}
 
 	return result
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+// evalPrefixExpression applies a prefix operator. checked is set for
+// checked arithmetic, as for evalInfixExpression.
+func evalPrefixExpression(operator string, right object.Object, checked bool) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusOperatorExpression(right)
+		return evalMinusOperatorExpression(right, checked)
+	case "~":
+		return evalTildeOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -184,41 +435,64 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 		return condition
 	}
 
-	if condition.(*object.Boolean).Value {
+	if IsTruthy(condition) {
 		return Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
 		return Eval(ie.Alternative, env)
 	} else {
-		return nil
+		return NULL
 	}
 }
 
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+// evalInfixExpression applies an infix operator. With checked set, integer
+// results which do not fit in an int64 are errors rather than big integers.
+func evalInfixExpression(operator string, left, right object.Object, checked bool) object.Object {
 	switch {
+	case left == NULL || right == NULL:
+		return evalNullInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right) && (left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+		return evalIntegerInfixExpression(operator, left, right, checked)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
 		return evalBooleanInfixExpression(operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
+	case left.Type() == object.ARRAY_OBJ && right.Type() == object.ARRAY_OBJ:
+		return evalArrayInfixExpression(operator, left, right)
 	}
 
 	return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 }
 
-func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+func evalIntegerInfixExpression(operator string, left, right object.Object, checked bool) object.Object {
+	switch {
+	case (operator == "/" || operator == "%") && sign(right) == 0:
+		return newError("division by zero")
+	case (operator == "<<" || operator == ">>") && sign(right) < 0:
+		return newError("negative shift count: %s", right.Inspect())
+	case operator == "**" && sign(right) < 0:
+		// Like division, a negative exponent makes a fraction.
+		return evalFloatInfixExpression(operator, left, right)
+	}
+
+	leftInt, leftOk := left.(*object.Integer)
+	rightInt, rightOk := right.(*object.Integer)
+	if !leftOk || !rightOk {
+		return evalBigIntegerInfixExpression(operator, toBigInt(left), toBigInt(right), checked)
+	}
+
+	leftVal := leftInt.Value
+	rightVal := rightInt.Value
 
 	switch operator {
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+	case "+", "-", "*", "/", "%", "**", "&", "|", "^", "<<", ">>":
+		if result, ok := IntegerArithmetic(operator, leftVal, rightVal); ok {
+			return &object.Integer{Value: result}
+		}
+		return evalBigIntegerInfixExpression(operator, big.NewInt(leftVal), big.NewInt(rightVal), checked)
 	case "<":
 		return &object.Boolean{Value: leftVal < rightVal}
 	case "<=":
@@ -235,6 +509,125 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 }
 
+// evalBigIntegerInfixExpression applies operator to integers of any size.
+// Results which fit in an int64 are Integers again.
+func evalBigIntegerInfixExpression(operator string, left, right *big.Int, checked bool) object.Object {
+	result := new(big.Int)
+
+	switch operator {
+	case "+":
+		result.Add(left, right)
+	case "-":
+		result.Sub(left, right)
+	case "*":
+		if left.BitLen()+right.BitLen() > maxIntegerBits {
+			return integerTooLarge()
+		}
+		result.Mul(left, right)
+	case "/":
+		result.Quo(left, right)
+	case "%":
+		result.Rem(left, right)
+	case "**":
+		// Powers of 0, 1 and -1 stay small for any exponent.
+		if bits := left.BitLen() - 1; bits > 0 && (!right.IsInt64() || right.Int64() > maxIntegerBits/int64(bits)) {
+			return integerTooLarge()
+		}
+		result.Exp(left, right, nil)
+	case "&":
+		result.And(left, right)
+	case "|":
+		result.Or(left, right)
+	case "^":
+		result.Xor(left, right)
+	case "<<":
+		if left.Sign() != 0 && (!right.IsInt64() || right.Int64() > int64(maxIntegerBits-left.BitLen())) {
+			return integerTooLarge()
+		}
+		if left.Sign() != 0 {
+			result.Lsh(left, uint(right.Int64()))
+		}
+	case ">>":
+		// Shifting by more than the length leaves only the sign.
+		shift := uint(left.BitLen() + 1)
+		if right.IsInt64() && right.Int64() < int64(shift) {
+			shift = uint(right.Int64())
+		}
+		result.Rsh(left, shift)
+	case "<":
+		return nativeBoolToBooleanObject(left.Cmp(right) < 0)
+	case "<=":
+		return nativeBoolToBooleanObject(left.Cmp(right) <= 0)
+	case ">":
+		return nativeBoolToBooleanObject(left.Cmp(right) > 0)
+	case ">=":
+		return nativeBoolToBooleanObject(left.Cmp(right) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(left.Cmp(right) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(left.Cmp(right) != 0)
+	default:
+		return newError("unknown operator: %s %s %s", object.INTEGER_OBJ, operator, object.INTEGER_OBJ)
+	}
+
+	if checked && !result.IsInt64() {
+		return newError("integer overflow: %d %s %d", left, operator, right)
+	}
+	return object.NewInteger(result)
+}
+
+// LiteralOverflow is the error of evaluating the integer literal value,
+// which does not fit in an int64, with checked arithmetic.
+func LiteralOverflow(value *big.Int) *object.Error {
+	return newError("integer overflow: literal %d does not fit in 64 bits", value)
+}
+
+func integerTooLarge() *object.Error {
+	return newError("integer too large: more than %d bits", maxIntegerBits)
+}
+
+// evalFloatInfixExpression applies operator to two numbers, at least one of
+// which is a float. Integers are converted to floats when mixed with them.
+func evalFloatInfixExpression(operator string, leftObj, rightObj object.Object) object.Object {
+	left, right := toFloat(leftObj), toFloat(rightObj)
+	switch operator {
+	case "+":
+		return &object.Float{Value: left + right}
+	case "-":
+		return &object.Float{Value: left - right}
+	case "*":
+		return &object.Float{Value: left * right}
+	case "/":
+		if right == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: left / right}
+	case "%":
+		if right == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Mod(left, right)}
+	case "**":
+		if left == 0 && right < 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Pow(left, right)}
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
+	case "<=":
+		return nativeBoolToBooleanObject(left <= right)
+	case ">":
+		return nativeBoolToBooleanObject(left > right)
+	case ">=":
+		return nativeBoolToBooleanObject(left >= right)
+	case "==":
+		return nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return nativeBoolToBooleanObject(left != right)
+	}
+	return newError("unknown operator: %s %s %s", leftObj.Type(), operator, rightObj.Type())
+}
+
 func evalBooleanInfixExpression(operator string, left, right object.Object) object.Object {
 	leftVal := left.(*object.Boolean).Value
 	rightVal := right.(*object.Boolean).Value
@@ -252,28 +645,205 @@ func evalBooleanInfixExpression(operator string, left, right object.Object) obje
 	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 }
 
+// evalNullInfixExpression compares null, which is only equal to itself.
+func evalNullInfixExpression(operator string, left, right object.Object) object.Object {
+	switch operator {
+	case "==":
+		return nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return nativeBoolToBooleanObject(left != right)
+	}
+	if left.Type() != right.Type() {
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
+	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+}
+
+// shortCircuits reports whether left alone decides the value of the
+// logical operator, so that the right operand must not be evaluated.
+func shortCircuits(operator string, left object.Object) bool {
+	boolean, ok := left.(*object.Boolean)
+	if !ok {
+		return false
+	}
+
+	switch operator {
+	case "&&":
+		return !boolean.Value
+	case "||":
+		return boolean.Value
+	}
+	return false
+}
+
+func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	}
+	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+}
+
+func evalArrayInfixExpression(operator string, left, right object.Object) object.Object {
+	leftElements := left.(*object.Array).Elements
+	rightElements := right.(*object.Array).Elements
+
+	switch operator {
+	case "+":
+		elements := make([]object.Object, 0, len(leftElements)+len(rightElements))
+		elements = append(elements, leftElements...)
+		elements = append(elements, rightElements...)
+		return &object.Array{Elements: elements}
+	}
+	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+}
+
+func evalIndexExpression(left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExpression(left, index)
+	default:
+		return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+// evalArrayIndexExpression returns the element at index, counting from the
+// end when index is negative, or NULL when index is out of range.
+func evalArrayIndexExpression(array, index object.Object) object.Object {
+	elements := array.(*object.Array).Elements
+	length := int64(len(elements))
+
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		// Big integers are out of range of any array.
+		return NULL
+	}
+	idx := integer.Value
+
+	if idx < 0 {
+		idx += length
+	}
+
+	if idx < 0 || idx >= length {
+		return NULL
+	}
+
+	return elements[idx]
+}
+
+func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	pairs := make(map[object.HashKey]object.HashPair, len(node.Pairs))
+
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
+		if isError(key) {
+			return key
+		}
+
+		hashable, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+
+		value := Eval(pair.Value, env)
+		if isError(value) {
+			return value
+		}
+
+		pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}
+}
+
+// evalHashIndexExpression returns the value stored for index, or NULL when
+// the hash has no such key.
+func evalHashIndexExpression(hash, index object.Object) object.Object {
+	hashable, ok := index.(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hash.(*object.Hash).Pairs[hashable.HashKey()]
+	if !ok {
+		return NULL
+	}
+
+	return pair.Value
+}
+
 func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
+	return nativeBoolToBooleanObject(!IsTruthy(right))
+}
+
+// IsTruthy reports whether obj counts as true in conditions. Only false and
+// null are falsy; every other value, including 0 and "", is truthy.
+func IsTruthy(obj object.Object) bool {
+	switch obj {
+	case NULL, FALSE:
+		return false
 	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	default:
-		return FALSE
+		return true
+	}
+
+	if boolean, ok := obj.(*object.Boolean); ok {
+		return boolean.Value
 	}
+	return true
 }
 
-func evalMinusOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+func evalMinusOperatorExpression(right object.Object, checked bool) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		if right.Value != math.MinInt64 {
+			return &object.Integer{Value: -right.Value}
+		}
+		return negateBigInteger(big.NewInt(right.Value), checked)
+	case *object.BigInteger:
+		return negateBigInteger(right.Value, checked)
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
+}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+// evalTildeOperatorExpression computes the bitwise complement, -x - 1,
+// which never overflows.
+func evalTildeOperatorExpression(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^right.Value}
+	case *object.BigInteger:
+		return object.NewInteger(new(big.Int).Not(right.Value))
+	default:
+		return newError("unknown operator: ~%s", right.Type())
+	}
+}
+
+func negateBigInteger(value *big.Int, checked bool) object.Object {
+	result := new(big.Int).Neg(value)
+	if checked && !result.IsInt64() {
+		return newError("integer overflow: -(%d)", value)
+	}
+	return object.NewInteger(result)
 }
 
 func evalBoolean(boolean *ast.Boolean) *object.Boolean {
-	if boolean.Value {
+	return nativeBoolToBooleanObject(boolean.Value)
+}
+
+func nativeBoolToBooleanObject(value bool) *object.Boolean {
+	if value {
 		return TRUE
 	}
 	return FALSE
@@ -284,5 +854,5 @@ func newError(format string, args ...interface{}) *object.Error {
 }
 
 func isError(obj object.Object) bool {
-	return obj.Type() == object.ERROR_OBJ
+	return obj != nil && obj.Type() == object.ERROR_OBJ
 }
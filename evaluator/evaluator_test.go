@@ -1,9 +1,17 @@
-package evaluator
+package evaluator_test
 
 import (
+	"bytes"
+	"github.com/moreal/monkey/ast"
+	"github.com/moreal/monkey/compiler"
+	"github.com/moreal/monkey/evaluator"
 	"github.com/moreal/monkey/lexer"
 	"github.com/moreal/monkey/object"
 	"github.com/moreal/monkey/parser"
+	"github.com/moreal/monkey/vm"
+	"io"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -25,7 +33,7 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -48,6 +56,10 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"true == true", true},
 		{"false == false", true},
 		{"true == false", false},
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"a" + "b" == "ab"`, true},
 		{"true != false", true},
 		{"true || false", true},
 		{"true && false", false},
@@ -55,7 +67,7 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -71,10 +83,13 @@ func TestBangOperator(t *testing.T) {
 		{"!!true", true},
 		{"!!5", true},
 		{"!5", false},
+		{"!0", false},
+		{`!""`, false},
+		{"!if (false) { 1 }", true},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -87,15 +102,290 @@ func TestIfExpression(t *testing.T) {
 		{"if (1 > 2) { 1 } else { 2 }", 2},
 		{"if (1 < 2) { 1 } else { 2 }", 1},
 		{"if (1 < 2) {} else {}", nil},
+		{"if (1 > 2) { 1 }", nil},
 		{"if (1  2) {}", nil},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		if expected, ok := tt.expected.(int); ok {
 			testIntegerObject(t, evaluated, int64(expected))
-		} else if tt.expected != evaluated {
-			t.Fatalf("Expected %+v but %+v", tt.expected, evaluated)
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4611686018427387904 * 2", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"-99999999999999999999", "-99999999999999999999"},
+		{"99999999999999999999 * 99999999999999999999", "9999999999999999999800000000000000000001"},
+		{"99999999999999999999 / 3", "33333333333333333333"},
+		{"-99999999999999999999 / 10000000000", "-9999999999"},
+		{"99999999999999999999 - 99999999999999999998", "1"},
+		{"9223372036854775808 - 1", "9223372036854775807"},
+		{"99999999999999999999 > 1", "true"},
+		{"1 >= 99999999999999999999", "false"},
+		{"-99999999999999999999 < 99999999999999999999", "true"},
+		{"99999999999999999999 == 99999999999999999999", "true"},
+		{"99999999999999999999 != 99999999999999999999 + 1", "true"},
+		{"9223372036854775808 - 1 == 9223372036854775807", "true"},
+		{"type(99999999999999999999)", "INTEGER"},
+		{"[1, 2][99999999999999999999]", "null"},
+		{`{99999999999999999999: "big", 1: "small"}[99999999999999999998 + 1]`, "big"},
+		{`{9223372036854775807: "max"}[9223372036854775808 - 1]`, "max"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(25)", "15511210043330985984000000"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("Expected %q to be %s but %s", tt.input, tt.expected, inspect(evaluated))
+		}
+	}
+
+	// Results which fit in an int64 are plain Integers again.
+	testIntegerObject(t, testEval(t, "9223372036854775808 - 1"), math.MaxInt64)
+}
+
+func TestFloats(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"3.14", "3.14"},
+		{"1e-9", "1e-09"},
+		{"-2.5", "-2.5"},
+		{"0.1 + 0.2", "0.30000000000000004"},
+		{"1.5 * 4", "6.0"},
+		{"2 * 1.5", "3.0"},
+		{"10 / 4", "2"},
+		{"10 / 4.0", "2.5"},
+		{"1 - 0.5", "0.5"},
+		{"99999999999999999999 + 0.5", "100000000000000000000.0"},
+		{"1.5 < 2", "true"},
+		{"2 <= 1.5", "false"},
+		{"2 == 2.0", "true"},
+		{"0.1 + 0.2 != 0.3", "true"},
+		{"type(1.0)", "FLOAT"},
+		{"if (0.0) { 1 } else { 2 }", "1"},
+		{"let half = fn(x) { x / 2.0 }; half(5)", "2.5"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("Expected %q to be %s but %s", tt.input, tt.expected, inspect(evaluated))
+		}
+	}
+}
+
+func TestArithmeticAndBitwiseOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"7 % 3", "1"},
+		{"-7 % 3", "-1"},
+		{"7 % -3", "1"},
+		{"(-9223372036854775807 - 1) % -1", "0"},
+		{"99999999999999999999 % 7", "1"},
+		{"7.5 % 2", "1.5"},
+		{"2 ** 10", "1024"},
+		{"2 ** 3 ** 2", "512"},
+		{"-2 ** 2", "-4"},
+		{"(-2) ** 3", "-8"},
+		{"2 ** 0", "1"},
+		{"2 ** 64", "18446744073709551616"},
+		{"2 ** -1", "0.5"},
+		{"4 ** 0.5", "2.0"},
+		{"1 ** 99999999999999999999", "1"},
+		{"(-1) ** 99999999999999999999", "-1"},
+		{"6 & 3", "2"},
+		{"6 | 3", "7"},
+		{"6 ^ 3", "5"},
+		{"-1 & 255", "255"},
+		{"99999999999999999999 & 4294967295", "1661992959"},
+		{"1 | 2 ^ 3 & 4", "3"},
+		{"~5", "-6"},
+		{"~-1", "0"},
+		{"~99999999999999999999", "-100000000000000000000"},
+		{"1 << 10", "1024"},
+		{"1 << 63", "9223372036854775808"},
+		{"-1 << 63", "-9223372036854775808"},
+		{"3 << 100", "3802951800684688204490109616128"},
+		{"0 << 99999999999999999999", "0"},
+		{"1024 >> 3", "128"},
+		{"-1024 >> 3", "-128"},
+		{"-1 >> 100", "-1"},
+		{"99999999999999999999 >> 40", "90949470"},
+		{"-99999999999999999999 >> 99999999999999999999", "-1"},
+		{"1 + 2 << 3", "24"},
+		{"7 % 0", "ERROR: 1:1: division by zero"},
+		{"99999999999999999999 % 0", "ERROR: 1:1: division by zero"},
+		{"1.5 % 0", "ERROR: 1:1: division by zero"},
+		{"0 ** -1", "ERROR: 1:1: division by zero"},
+		{"1 << -1", "ERROR: 1:1: negative shift count: -1"},
+		{"1 >> -99999999999999999999", "ERROR: 1:1: negative shift count: -99999999999999999999"},
+		{"2 ** 99999999999999999999", "ERROR: 1:1: integer too large: more than 16777216 bits"},
+		{"1 << 99999999999999999999", "ERROR: 1:1: integer too large: more than 16777216 bits"},
+		{"1.5 & 1", "ERROR: 1:1: unknown operator: FLOAT & INTEGER"},
+		{"1 << 2.0", "ERROR: 1:1: unknown operator: INTEGER << FLOAT"},
+		{"~1.5", "ERROR: 1:1: unknown operator: ~FLOAT"},
+		{"true ^ false", "ERROR: 1:1: unknown operator: BOOLEAN ^ BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("Expected %q to be %s but %s", tt.input, tt.expected, inspect(evaluated))
+		}
+	}
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"9223372036854775806 + 1", math.MaxInt64},
+		{"-9223372036854775807 - 1", math.MinInt64},
+		{"-4611686018427387904 * 2", math.MinInt64},
+		{"-3037000499 * 3037000499", -9223372030926249001},
+		{"9223372036854775807 + 1", "integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2", "integer overflow: -9223372036854775807 - 2"},
+		{"1 - -9223372036854775807 - 1", "integer overflow: 1 - -9223372036854775807"},
+		{"4611686018427387904 * 2", "integer overflow: 4611686018427387904 * 2"},
+		{"-3037000500 * -3037000500", "integer overflow: -3037000500 * -3037000500"},
+		{"9223372036854775808 - 2", "integer overflow: literal 9223372036854775808 does not fit in 64 bits"},
+		{"99999999999999999999 * 0", "integer overflow: literal 99999999999999999999 does not fit in 64 bits"},
+		{"1 + -99999999999999999999", "integer overflow: literal 99999999999999999999 does not fit in 64 bits"},
+		{"(-9223372036854775807 - 1) * -1", "integer overflow: -9223372036854775808 * -1"},
+		{"(-9223372036854775807 - 1) / -1", "integer overflow: -9223372036854775808 / -1"},
+		{"-(-9223372036854775807 - 1)", "integer overflow: -(-9223372036854775808)"},
+		{"3 ** 39", 4052555153018976267},
+		{"2 ** 63", "integer overflow: 2 ** 63"},
+		{"1 << 62", 4611686018427387904},
+		{"1 << 63", "integer overflow: 1 << 63"},
+		{"~(-9223372036854775807 - 1)", math.MaxInt64},
+		{"let sum = fn(n) { if (n == 0) { 0 } else { 4000000000000000000 + sum(n - 1) } }; sum(3)", "integer overflow: 4000000000000000000 + 8000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalArithmetic(t, tt.input, true)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Expected 'Error' for %q but '%T'", tt.input, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("Expected error message '%s' but '%s'", expected, errObj.Message)
+			}
+		}
+	}
+
+	// The mode is chosen for each evaluation.
+	if evaluated := testEval(t, "9223372036854775807 + 1"); evaluated.Inspect() != "9223372036854775808" {
+		t.Errorf("Expected unchecked arithmetic to give 9223372036854775808 but %s", inspect(evaluated))
+	}
+}
+
+func TestTruthiness(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"if (1) { 1 } else { 2 }", 1},
+		{"if (0) { 1 } else { 2 }", 1},
+		{`if ("") { 1 } else { 2 }`, 1},
+		{"if ([]) { 1 } else { 2 }", 1},
+		{"if ({}) { 1 } else { 2 }", 1},
+		{"if (fn() {}) { 1 } else { 2 }", 1},
+		{"if (len) { 1 } else { 2 }", 1},
+		{"if (1 == 1) { 1 } else { 2 }", 1},
+		{"if (false) { 1 } else { 2 }", 2},
+		{"if (if (false) { 1 }) { 1 } else { 2 }", 2},
+		{"if (puts()) { 1 } else { 2 }", 2},
+		{"if ([][0]) { 1 } else { 2 }", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 0; x != 0 && 10 / x > 1", "false"},
+		{"let x = 0; x == 0 || 10 / x > 1", "true"},
+		{"let x = 5; x != 0 && 10 / x > 1", "true"},
+		{"false && undefined", "false"},
+		{"true || undefined", "true"},
+		{"let loop = fn() { loop() }; false && loop()", "false"},
+		{"let check = fn(xs) { len(xs) > 0 && xs[0] == 1 }; [check([]), check([1]), check([2])]", "[false, true, false]"},
+		{"false || 1 < 2 && 2 < 3", "true"},
+		{"true && (false || true)", "true"},
+		{"true && 1", "ERROR: 1:1: type mismatch: BOOLEAN && INTEGER"},
+		{"false || 1 / 0", "ERROR: 1:10: division by zero"},
+		{"1 || true", "ERROR: 1:1: type mismatch: INTEGER || BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("Expected %q to be %s but %s", tt.input, tt.expected, inspect(evaluated))
+		}
+	}
+}
+
+func TestNull(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"null", "null"},
+		{"", "null"},
+		{"fn() {}()", "null"},
+		{"let f = fn(x) { if (x) { 1 } }; f(false)", "null"},
+		{"[null, 1][0]", "null"},
+		{"null == null", "true"},
+		{"null != null", "false"},
+		{"if (false) { 1 } == null", "true"},
+		{"puts() == null", "true"},
+		{"null == false", "false"},
+		{"0 != null", "true"},
+		{`[1, "", [], null] == null`, "false"},
+		{"!null", "true"},
+		{"if (null) { 1 } else { 2 }", "2"},
+		{"null && true", "ERROR: 1:1: type mismatch: NULL && BOOLEAN"},
+		{"null + 1", "ERROR: 1:1: type mismatch: NULL + INTEGER"},
+		{"null + null", "ERROR: 1:1: unknown operator: NULL + NULL"},
+		{"-null", "ERROR: 1:1: unknown operator: -NULL"},
+		{"{null: 1}", "ERROR: 1:1: unusable as hash key: NULL"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("Expected %q to be %s but %s", tt.input, tt.expected, inspect(evaluated))
 		}
 	}
 }
@@ -107,6 +397,9 @@ func TestReturnStatements(t *testing.T) {
 	}{
 		{"return 10;", 10},
 		{"return 10; 9;", 10},
+		{"return 10", 10},
+		{"return 10\n9", 10},
+		{"let f = fn(x) { if (x > 1) { return x }; 0 }\nf(10)", 10},
 		{`
 if (1 < 10) {
   if (1 < 10) {
@@ -118,7 +411,7 @@ if (1 < 10) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -134,6 +427,34 @@ func TestErrorHandling(t *testing.T) {
 		{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"5; true + false; 5;", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"5; 1 && 20; 5;", "unknown operator: INTEGER && INTEGER"},
+		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{`"Hello" + 1`, "type mismatch: STRING + INTEGER"},
+		{"[1] - [2]", "unknown operator: ARRAY - ARRAY"},
+		{`[1, 2]["a"]`, "index operator not supported: ARRAY[STRING]"},
+		{"1[0]", "index operator not supported: INTEGER[INTEGER]"},
+		{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+		{`{[1]: 2}`, "unusable as hash key: ARRAY"},
+		// Keys are checked before their values are evaluated.
+		{`{[1]: 1 / 0}`, "unusable as hash key: ARRAY"},
+		{`{fn() { 1 }: puts("side")}`, "unusable as hash key: FUNCTION"},
+		{"1 / 0", "division by zero"},
+		{"1.5 / 0", "division by zero"},
+		{"1.5 + true", "type mismatch: FLOAT + BOOLEAN"},
+		{`"a" * 1.5`, "type mismatch: STRING * FLOAT"},
+		{"1.5 && 2", "unknown operator: FLOAT && INTEGER"},
+		{"[1][0.0]", "index operator not supported: ARRAY[FLOAT]"},
+		{"{1.5: 1}", "unusable as hash key: FLOAT"},
+		{"let a = 5; a / (a - a)", "division by zero"},
+		{"let f = fn(a, b) { a }; f(1)", "wrong number of arguments: want 2, got 1"},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want 2, got 1"},
+		{"fn(a) { a }(1, 2)", "wrong number of arguments: want 1, got 2"},
+		{"let f = fn() { 1 }; f(f)", "wrong number of arguments: want 0, got 1"},
+		{"let f = fn(a, b) { a + b }; f(1, f(2))", "wrong number of arguments: want 2, got 1"},
+		{"first()", "wrong number of arguments: want 1, got 0"},
+		{"let f = fn() { f() }; f()", "stack overflow"},
+		{"1()", "not a function: INTEGER"},
+		{"fn() { if (false) { let y = 1 }; y }()", "identifier not found: y"},
+		{"fn(x) { if (x) { let y = 1 }; fn() { y } }(false)()", "identifier not found: y"},
 		{`
 if (1 < 10) {
   if (1 < 10) {
@@ -145,7 +466,7 @@ if (1 < 10) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 
 		errObj, ok := evaluated.(*object.Error)
 		if !ok {
@@ -159,6 +480,53 @@ if (1 < 10) {
 	}
 }
 
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+	}{
+		{"foobar", "1:1"},
+		{"let a = 1;\nlet b = a + true;", "2:9"},
+		{"let f = fn(x) {\n  -x\n};\nf(true)", "2:3"},
+		{"let f = fn(x) {\n  f(x)\n};\nf(1)", "2:3"},
+		{"let f = fn(a, b) { a };\nlet x = 1 + f(1, 2, 3);", "2:13"},
+		{"let g = fn(f) {\n  f(1)\n};\ng(fn() { 0 })", "2:3"},
+		{"[1, 2, len()]", "1:8"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("Expected 'Error' but '%T'", evaluated)
+			continue
+		}
+
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("Expected error at %s but %s", tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestEvalDepth(t *testing.T) {
+	// Each call only nests a few calls deep, but evaluates deeply nested
+	// expressions.
+	input := "let f = fn(n) { if (n == 0) { 0 } else { " +
+		strings.Repeat("0 + (", 3000) + "f(n - 1)" + strings.Repeat(")", 3000) +
+		" } }; f(1000)"
+
+	evaluated := testEvalTreeWalking(input, false)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("Expected 'Error' but '%T'", evaluated)
+	}
+	if errObj.Message != "stack overflow" {
+		t.Fatalf("Expected error message 'stack overflow' but '%s'", errObj.Message)
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -168,6 +536,8 @@ func TestLetStatements(t *testing.T) {
 		{"let a = 10; a; 5;", 5},
 		{"let a = 10; let b = a; b;", 10},
 		{"let a = 10; let b = a + 5; b;", 15},
+		{"let a = 10\nlet b = a + 5\nb", 15},
+		{"let a = 10; let b = a * 2\nb", 20},
 		{`
 let a = 5 * 5;
 if (1 < 10) {
@@ -180,7 +550,7 @@ if (1 < 10) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -189,7 +559,7 @@ func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 	expectedBody := "(x + 2)"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	fn, ok := evaluated.(*object.Function)
 	if !ok {
 		t.Fatalf("Expected 'Function' but '%T'", evaluated)
@@ -209,10 +579,42 @@ func TestFunctionObject(t *testing.T) {
 }
 
 func TestClosure(t *testing.T) {
-	input := `fn(x){let a = x * 2; fn(){a}}(5)()`
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"fn(x){let a = x * 2; fn(){a}}(5)()", 10},
+		{"let add = fn(a) { fn(b) { a + b } }; let addTwo = add(2); addTwo(3)", 5},
+		{"let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)", 6},
+		{"let a = 1; let f = fn(b) { fn() { a + b } }; let a = 10; f(2)()", 12},
+		// Closures share the variables they capture with the function
+		// defining them, so they see later let statements.
+		{"let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()", 2},
+		{"let f = fn() { let x = 1; let g = fn() { fn() { x } }; let x = 3; g()() }; f()", 3},
+		{"let f = fn() { let g = fn() { g }; let h = g; let g = 5; h() }; f()", 5},
+		{"let f = fn() { let a = fn() { b() }; let b = fn() { 1 }; a() }; f()", 1},
+		// Names whose let has not run yet still refer to outer variables.
+		{"let x = 1; let f = fn() { let y = x; let x = 2; y * 10 + x }; f()", 12},
+		{"let x = 1; let f = fn() { let g = fn() { x }; let y = g(); let x = 2; y * 10 + g() }; f()", 12},
+		{`let f = fn() { len("ab") }; let a = f(); let len = fn(x) { 0 }; a * 10 + f()`, 20},
+		{"let newAdders = fn(a) { [fn(b) { a + b }, fn(b) { a - b }] }; let adders = newAdders(10); adders[0](1) * adders[1](1)", 99},
+		{`
+let wrapper = fn() {
+	let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } };
+	countDown(3) + 1;
+};
+wrapper();`, 1},
+		{`
+let outer = fn(n) {
+	let sum = fn(x) { if (x == 0) { n } else { fn() { sum(x - 1) }() + x } };
+	sum(3);
+};
+outer(10);`, 16},
+	}
 
-	evaluated := testEval(input)
-	testIntegerObject(t, evaluated, 10)
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
+	}
 }
 
 func TestFunctionCallExpression(t *testing.T) {
@@ -229,18 +631,422 @@ func TestFunctionCallExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
 
-func testEval(input string) object.Object {
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(a, b = 10) { a + b }; [f(1), f(1, 2)]", "[11, 3]"},
+		{"let f = fn(a, b = a * 2, c = a + b) { [a, b, c] }; [f(1), f(1, 5), f(1, 5, 0)]", "[[1, 2, 3], [1, 5, 6], [1, 5, 0]]"},
+		{"let f = fn(...rest) { rest }; [f(), f(1), f(1, 2, 3)]", "[[], [1], [1, 2, 3]]"},
+		{"let f = fn(a, b = 2, ...rest) { [a, b, rest] }; [f(1), f(1, 3), f(1, 3, 5, 7)]", "[[1, 2, []], [1, 3, []], [1, 3, [5, 7]]]"},
+		// Defaults are evaluated at each call, in the environment of the
+		// function.
+		{"let n = 1; let f = fn(x = n) { x }; let n = 2; f()", "2"},
+		{"let make = fn(k) { fn(x = k) { x } }; make(5)()", "5"},
+		{"let f = fn(xs = []) { push(xs, 1) }; f(); f()", "[1]"},
+		{"let b = 100; let f = fn(a = b, b = 1) { [a, b] }; f()", "[100, 1]"},
+		{"let f = fn(a = if (true) { return 7; }) { 0 }; [f(), f(1)]", "[7, 0]"},
+		{"let f = fn(a, b = 1) { a }; f()", "ERROR: 1:29: wrong number of arguments: want 1 to 2, got 0"},
+		{"let f = fn(a, b = 1) { a }; f(1, 2, 3)", "ERROR: 1:29: wrong number of arguments: want 1 to 2, got 3"},
+		{"let f = fn(a, ...rest) { a }; f()", "ERROR: 1:31: wrong number of arguments: want at least 1, got 0"},
+		{"let f = fn(a = 1 / 0) { a }; f()", "ERROR: 1:16: division by zero"},
+		{"fn(a, b = 2, ...rest) { a }", "fn(a, b = 2, ...rest) {\na\n}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("Expected %q to be %s but %s", tt.input, tt.expected, inspect(evaluated))
+		}
+	}
+}
+
+func TestNamedArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(a, b) { [a, b] }; [f(a: 1, b: 2), f(b: 2, a: 1), f(1, b: 2)]", "[[1, 2], [1, 2], [1, 2]]"},
+		{"let f = fn(a, b = 2, c = b * 10) { [a, b, c] }; [f(1, c: 3), f(a: 1), f(c: 3, a: 1)]", "[[1, 2, 3], [1, 2, 20], [1, 2, 3]]"},
+		{"let f = fn(a, ...rest) { [a, rest] }; [f(a: 1), f(1, 2, 3)]", "[[1, []], [1, [2, 3]]]"},
+		{"let f = fn(a, b) { a - b }; f(b: puts(), a: 1)", "ERROR: 1:20: type mismatch: INTEGER - NULL"},
+		{`[push(array: [1], element: 2), push([1], element: 2), len(value: "abc")]`, "[[1, 2], [1, 2], 3]"},
+		{"let f = fn(a) { a }; f(b: 1)", "ERROR: 1:22: no parameter named b"},
+		{"let f = fn(a, ...rest) { a }; f(1, rest: 2)", "ERROR: 1:31: no parameter named rest"},
+		{"let f = fn(a, b) { a }; f(1, a: 2)", "ERROR: 1:25: multiple values for parameter a"},
+		{"let f = fn(a, b) { a }; f(b: 2)", "ERROR: 1:25: missing argument for parameter a"},
+		{"let f = fn(a) { a }; f(1, 2, a: 3)", "ERROR: 1:22: wrong number of arguments: want 1, got 3"},
+		{"push(element: 1)", "ERROR: 1:1: missing argument for parameter array"},
+		{"puts(value: 1)", "ERROR: 1:1: no parameter named value"},
+		{"1(a: 1)", "ERROR: 1:1: not a function: INTEGER"},
+		{"let f = fn(a) { a }; f(a: 1 / 0)", "ERROR: 1:27: division by zero"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("Expected %q to be %s but %s", tt.input, tt.expected, inspect(evaluated))
+		}
+	}
+}
+
+func TestStringLiteral(t *testing.T) {
+	input := `"Hello\tWorld!"`
+
+	evaluated := testEval(t, input)
+	testStringObject(t, evaluated, "Hello\tWorld!")
+}
+
+func TestStringConcatenation(t *testing.T) {
+	input := `let greet = fn(name) { "Hello, " + name + "!" }; greet("World")`
+
+	evaluated := testEval(t, input)
+	testStringObject(t, evaluated, "Hello, World!")
+
+	evaluated = testEval(t, `let 인사 = fn(이름) { "안녕, " + 이름 + "!" }; 인사("세계")`)
+	testStringObject(t, evaluated, "안녕, 세계!")
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len("한국어")`, 3},
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments: want 1, got 2"},
+		{`first("abc")`, "a"},
+		{`first("한국")`, "한"},
+		{`first("")`, nil},
+		{`first(1)`, "argument to `first` not supported, got INTEGER"},
+		{`last("abc")`, "c"},
+		{`last("")`, nil},
+		{`rest("abc")`, "bc"},
+		{`rest(rest("abc"))`, "c"},
+		{`rest("")`, nil},
+		{`type(1)`, "INTEGER"},
+		{`type("a")`, "STRING"},
+		{`type(true)`, "BOOLEAN"},
+		{`type(len)`, "BUILTIN"},
+		{`type(fn(){})`, "FUNCTION"},
+		{`let len = fn(x) { 42 }; len("abc")`, 42},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, nil},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, nil},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([])`, nil},
+		{`push([], 1)`, []int{1}},
+		{`let a = [1]; push(a, 2); a`, []int{1}},
+		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`push([])`, "wrong number of arguments: want 2, got 1"},
+		{`type([])`, "ARRAY"},
+		{`len({"a": 1, "b": 2})`, 2},
+		{`type({})`, "HASH"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("Expected error message '%s' but '%s'", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		case []int:
+			testIntegerArrayObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	count := len(evaluator.Builtins())
+	t.Run("Register", testRegisterBuiltin)
+
+	if len(evaluator.Builtins()) != count {
+		t.Fatalf("Expected %d builtins after the test but %d", count, len(evaluator.Builtins()))
+	}
+	if _, ok := testEval(t, "double").(*object.Error); !ok {
+		t.Fatalf("Expected double to be unregistered after the test")
+	}
+}
+
+func testRegisterBuiltin(t *testing.T) {
+	evaluator.RestoreBuiltins(t)
+	evaluator.RegisterBuiltin("double", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+
+	evaluated := testEval(t, "double(21)")
+	testIntegerObject(t, evaluated, 42)
+
+	// Host functions may return nil for no value.
+	evaluator.RegisterBuiltin("nothing", func(args ...object.Object) object.Object {
+		return nil
+	})
+
+	testNullObject(t, testEval(t, "nothing()"))
+
+	// Host functions may keep their arguments.
+	evaluator.RegisterBuiltin("pack", func(args ...object.Object) object.Object {
+		return &object.Array{Elements: args}
+	})
+
+	evaluated = testEval(t, "let a = pack(1, 2); let b = [7, 8, 9, 10]; a")
+	testIntegerArrayObject(t, evaluated, []int{1, 2})
+}
+
+func TestPuts(t *testing.T) {
+	var out bytes.Buffer
+	defer func(output io.Writer) { evaluator.Output = output }(evaluator.Output)
+	evaluator.Output = &out
+
+	testNullObject(t, testEval(t, `puts("hi", 1, [2])`))
+
+	// testEval runs the program on both backends.
+	expected := "hi\n1\n[2]\nhi\n1\n[2]\n"
+	if out.String() != expected {
+		t.Fatalf("Expected output %q but %q", expected, out.String())
+	}
+}
+
+func TestRecoverFromPanic(t *testing.T) {
+	evaluator.RestoreBuiltins(t)
+	evaluator.RegisterBuiltin("crash", func(args ...object.Object) object.Object {
+		panic("boom")
+	})
+
+	evaluated := testEval(t, "1 + crash()")
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("Expected 'Error' but '%T'", evaluated)
+	}
+	if errObj.Message != "internal error: boom" {
+		t.Fatalf("Expected error message 'internal error: boom' but '%s'", errObj.Message)
+	}
+
+	// Any node may be evaluated on its own, not only programs.
+	env := object.NewEnvironment()
+	broken := &ast.IfExpression{Condition: &ast.Boolean{Value: true}}
+	evaluated = evaluator.Eval(broken, env)
+
+	errObj, ok = evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("Expected 'Error' but '%T'", evaluated)
+	}
+	if !strings.HasPrefix(errObj.Message, "internal error: ") {
+		t.Fatalf("Expected an internal error but '%s'", errObj.Message)
+	}
+	testIntegerObject(t, evaluator.Eval(&ast.IntegerLiteral{Value: 1}, env), 1)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	evaluated := testEval(t, input)
+	testIntegerArrayObject(t, evaluated, []int{1, 4, 6})
+}
+
+func TestArrayConcatenation(t *testing.T) {
+	input := "let a = [1, 2]; let b = a + [3]; a + b"
+
+	evaluated := testEval(t, input)
+	testIntegerArrayObject(t, evaluated, []int{1, 2, 1, 2, 3})
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][2]", 3},
+		{"let i = 0; [1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"let myArray = [1, 2, 3]; myArray[2];", 3},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-4]", nil},
+		{"[][0]", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if expected, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(expected))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `let two = "two";
+{
+  "one": 10 - 9,
+  two: 1 + 1,
+  "thr" + "ee": 6 / 2,
+  4: 4,
+  true: 5,
+  false: 6
+}`
+
+	evaluated := testEval(t, input)
+	result, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("Expected 'Hash' but '%T'", evaluated)
+	}
+
+	expected := map[object.HashKey]int64{
+		(&object.String{Value: "one"}).HashKey():   1,
+		(&object.String{Value: "two"}).HashKey():   2,
+		(&object.String{Value: "three"}).HashKey(): 3,
+		(&object.Integer{Value: 4}).HashKey():      4,
+		evaluator.TRUE.HashKey():                   5,
+		evaluator.FALSE.HashKey():                  6,
+	}
+
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Expected %d pairs but %d", len(expected), len(result.Pairs))
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Fatalf("No pair for the given key in Pairs")
+		}
+
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+
+	if result.Inspect() != "{4: 4, false: 6, one: 1, three: 3, true: 5, two: 2}" {
+		t.Fatalf("Unexpected Inspect() '%s'", result.Inspect())
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+		{`{1: 1, 1: 2}[1]`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if expected, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(expected))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+// testEval evaluates input with Eval and checks that the compiled backend
+// produces the same result.
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+	return testEvalArithmetic(t, input, false)
+}
+
+// testEvalArithmetic is testEval with checked arithmetic if checked is set.
+func testEvalArithmetic(t *testing.T, input string, checked bool) object.Object {
+	t.Helper()
+
+	evaluated := testEvalTreeWalking(input, checked)
+	executed := testEvalBytecode(input, checked)
+
+	if !sameResult(evaluated, executed) {
+		t.Errorf("Backends disagree on %q: Eval gave '%s' but the VM gave '%s'", input, inspect(evaluated), inspect(executed))
+	}
+
+	return evaluated
+}
+
+func testEvalTreeWalking(input string, checked bool) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 
 	env := object.NewEnvironment()
+	env.SetCheckedArithmetic(checked)
 
-	return Eval(p.ParseProgram(), env)
+	return evaluator.Eval(p.ParseProgram(), env)
+}
+
+func testEvalBytecode(input string, checked bool) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	comp := compiler.New()
+	if err := comp.Compile(p.ParseProgram()); err != nil {
+		return &object.Error{Message: "compiler error: " + err.Error()}
+	}
+
+	machine := vm.New(comp.Bytecode())
+	machine.SetCheckedArithmetic(checked)
+	if err := machine.Run(); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: "vm error: " + err.Error()}
+	}
+
+	return machine.LastPoppedStackElem()
+}
+
+func sameResult(evaluated, executed object.Object) bool {
+	if evaluated == nil || executed == nil {
+		return false
+	}
+
+	switch evaluated := evaluated.(type) {
+	case *object.Error:
+		errObj, ok := executed.(*object.Error)
+		return ok && evaluated.Message == errObj.Message && evaluated.Pos == errObj.Pos
+	case *object.Function:
+		// Only compiled functions can be inspected.
+		return executed.Type() == object.FUNCTION_OBJ
+	}
+
+	return evaluated.Type() == executed.Type() && evaluated.Inspect() == executed.Inspect()
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return obj.Inspect()
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) {
@@ -254,6 +1060,38 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) {
 	}
 }
 
+func testStringObject(t *testing.T, obj object.Object, expected string) {
+	str, ok := obj.(*object.String)
+	if !ok {
+		t.Fatalf("Expected 'String' but '%T'", obj)
+	}
+
+	if str.Value != expected {
+		t.Fatalf("Expected %q but %q", expected, str.Value)
+	}
+}
+
+func testIntegerArrayObject(t *testing.T, obj object.Object, expected []int) {
+	array, ok := obj.(*object.Array)
+	if !ok {
+		t.Fatalf("Expected 'Array' but '%T'", obj)
+	}
+
+	if len(array.Elements) != len(expected) {
+		t.Fatalf("Expected %d elements but %d", len(expected), len(array.Elements))
+	}
+
+	for i, element := range expected {
+		testIntegerObject(t, array.Elements[i], int64(element))
+	}
+}
+
+func testNullObject(t *testing.T, obj object.Object) {
+	if obj != evaluator.NULL {
+		t.Fatalf("Expected NULL but '%+v'", obj)
+	}
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) {
 	boolean, ok := obj.(*object.Boolean)
 	if !ok {
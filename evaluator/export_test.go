@@ -0,0 +1,13 @@
+package evaluator
+
+import (
+	"github.com/moreal/monkey/object"
+	"testing"
+)
+
+// RestoreBuiltins undoes the builtins registered by t when it ends, so that
+// they neither leak into later tests nor shift the indexes of builtins.
+func RestoreBuiltins(t *testing.T) {
+	saved := append([]*object.Builtin(nil), builtins...)
+	t.Cleanup(func() { builtins = saved })
+}
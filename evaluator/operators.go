@@ -0,0 +1,20 @@
+package evaluator
+
+import "github.com/moreal/monkey/object"
+
+// EvalPrefix, EvalInfix and EvalIndex apply an operator to operands which
+// are already evaluated. Other backends use them so that operators behave
+// exactly as in Eval. checked selects checked arithmetic, as
+// Environment.SetCheckedArithmetic does for Eval.
+
+func EvalPrefix(operator string, right object.Object, checked bool) object.Object {
+	return evalPrefixExpression(operator, right, checked)
+}
+
+func EvalInfix(operator string, left, right object.Object, checked bool) object.Object {
+	return evalInfixExpression(operator, left, right, checked)
+}
+
+func EvalIndex(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
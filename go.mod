@@ -1,3 +1,3 @@
 module github.com/moreal/monkey
 
-go 1.17
+go 1.18
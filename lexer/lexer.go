@@ -1,22 +1,87 @@
 package lexer
 
-import "github.com/moreal/monkey/token"
+import (
+	"fmt"
+	"github.com/moreal/monkey/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
+// Error is a lexical error. The offending input is returned as an ILLEGAL
+// token starting at Pos. Unterminated is set when the input ended in the
+// middle of the token, so more input could make it valid.
+type Error struct {
+	Pos          token.Position
+	Message      string
+	Unterminated bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// Lexer splits UTF-8 encoded input into tokens. Columns count characters,
+// while offsets count bytes.
 type Lexer struct {
-	input        string
+	filename string
+	input    string
+	// position is the offset of ch, and readPosition the offset of the
+	// character after it.
 	position     int
 	readPosition int
-	ch           byte
+	ch           rune
+
+	// line and column of ch.
+	line   int
+	column int
+
+	errors []*Error
+
+	keepComments bool
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile returns a lexer whose token positions refer to filename.
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{filename: filename, input: input, line: 1}
 	l.readChar()
 	return l
 }
 
+// Errors returns the lexical errors found so far, one per ILLEGAL token.
+func (l *Lexer) Errors() []*Error {
+	return l.errors
+}
+
+// KeepComments makes NextToken return comments as COMMENT tokens, whose
+// literal is the whole comment, instead of skipping them like whitespace.
+func (l *Lexer) KeepComments(keep bool) {
+	l.keepComments = keep
+}
+
 func (l *Lexer) NextToken() (tok token.Token) {
-	l.skipWhitespaces()
+	for {
+		l.skipWhitespaces()
+		if l.ch != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			break
+		}
+
+		comment := l.readComment()
+		if l.keepComments || comment.Type == token.ILLEGAL {
+			return comment
+		}
+	}
+
+	start := l.currentPosition()
+	defer func() {
+		tok.Start = start
+		tok.End = l.currentPosition()
+	}()
 
 	switch l.ch {
 	case '=':
@@ -32,7 +97,13 @@ func (l *Lexer) NextToken() (tok token.Token) {
 	case '-':
 		tok = newTokenWithChar(token.MINUS, l.ch)
 	case '*':
-		tok = newTokenWithChar(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			ch := l.ch
+			l.readChar()
+			tok = newToken(token.POWER, string(ch)+string(l.ch))
+		} else {
+			tok = newTokenWithChar(token.ASTERISK, l.ch)
+		}
 	case '(':
 		tok = newTokenWithChar(token.LPAREN, l.ch)
 	case ')':
@@ -41,6 +112,10 @@ func (l *Lexer) NextToken() (tok token.Token) {
 		tok = newTokenWithChar(token.LBRACE, l.ch)
 	case '}':
 		tok = newTokenWithChar(token.RBRACE, l.ch)
+	case '[':
+		tok = newTokenWithChar(token.LBRACKET, l.ch)
+	case ']':
+		tok = newTokenWithChar(token.RBRACKET, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -54,6 +129,10 @@ func (l *Lexer) NextToken() (tok token.Token) {
 			ch := l.ch
 			l.readChar()
 			tok = newToken(token.LTE, string(ch)+string(l.ch))
+		} else if l.peekChar() == '<' {
+			ch := l.ch
+			l.readChar()
+			tok = newToken(token.SHL, string(ch)+string(l.ch))
 		} else {
 			tok = newTokenWithChar(token.LT, l.ch)
 		}
@@ -62,15 +141,44 @@ func (l *Lexer) NextToken() (tok token.Token) {
 			ch := l.ch
 			l.readChar()
 			tok = newToken(token.GTE, string(ch)+string(l.ch))
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = newToken(token.SHR, string(ch)+string(l.ch))
 		} else {
 			tok = newTokenWithChar(token.GT, l.ch)
 		}
 	case '/':
 		tok = newTokenWithChar(token.SLASH, l.ch)
+	case '%':
+		tok = newTokenWithChar(token.PERCENT, l.ch)
+	case '^':
+		tok = newTokenWithChar(token.CARET, l.ch)
+	case '~':
+		tok = newTokenWithChar(token.TILDE, l.ch)
 	case ',':
 		tok = newTokenWithChar(token.COMMA, l.ch)
 	case ';':
 		tok = newTokenWithChar(token.SEMICOLON, l.ch)
+	case ':':
+		tok = newTokenWithChar(token.COLON, l.ch)
+	case '.':
+		if l.peekChar() == '.' && l.peekCharAt(2) == '.' {
+			l.readChar()
+			l.readChar()
+			tok = newToken(token.ELLIPSIS, "...")
+		} else {
+			l.addError(start, "illegal character %q", l.ch)
+			tok = newTokenWithChar(token.ILLEGAL, l.ch)
+		}
+	case '"':
+		if literal, ok := l.readString(); ok {
+			tok = newToken(token.STRING, literal)
+		} else if l.isAtEnd() {
+			return newToken(token.ILLEGAL, l.input[start.Offset:])
+		} else {
+			tok = newToken(token.ILLEGAL, l.input[start.Offset:l.position+1])
+		}
 	case '&':
 		if l.peekChar() == '&' {
 			ch := l.ch
@@ -89,15 +197,19 @@ func (l *Lexer) NextToken() (tok token.Token) {
 		}
 	case 0:
 		tok = newToken(token.EOF, "")
+		return
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = identifierToTokenType(tok.Literal)
 			return
 		} else if isDigits(l.ch) {
-			tok = newToken(token.INT, l.readInteger())
+			tok.Type, tok.Literal = l.readNumber()
 			return
+		} else if !l.checkEncoding() {
+			tok = newToken(token.ILLEGAL, l.input[l.position:l.readPosition])
 		} else {
+			l.addError(start, "illegal character %q", l.ch)
 			tok = newTokenWithChar(token.ILLEGAL, l.ch)
 		}
 	}
@@ -115,6 +227,7 @@ func identifierToTokenType(identifier string) token.TokenType {
 		"return": token.RETURN,
 		"true":   token.TRUE,
 		"false":  token.FALSE,
+		"null":   token.NULL,
 	}
 
 	if tok, ok := keywords[identifier]; ok {
@@ -130,10 +243,51 @@ func (l *Lexer) skipWhitespaces() {
 	}
 }
 
-func isWhitespace(char byte) bool {
+func isWhitespace(char rune) bool {
 	return char == ' ' || char == '\n' || char == '\t' || char == '\r'
 }
 
+// readComment reads a // comment up to the end of the line, or a /* */
+// comment, which may span lines.
+func (l *Lexer) readComment() token.Token {
+	start := l.currentPosition()
+	tokenType := token.TokenType(token.COMMENT)
+
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && !l.isAtEnd() {
+			if !l.checkEncoding() {
+				tokenType = token.ILLEGAL
+			}
+			l.readChar()
+		}
+	} else {
+		l.readChar()
+		for {
+			l.readChar()
+			if !l.checkEncoding() {
+				tokenType = token.ILLEGAL
+			}
+			if l.isAtEnd() {
+				l.errors = append(l.errors, &Error{Pos: start, Message: "unterminated comment", Unterminated: true})
+				tokenType = token.ILLEGAL
+				break
+			}
+			if l.ch == '*' && l.peekChar() == '/' {
+				l.readChar()
+				l.readChar()
+				break
+			}
+		}
+	}
+
+	return token.Token{
+		Type:    tokenType,
+		Literal: l.input[start.Offset:l.position],
+		Start:   start,
+		End:     l.currentPosition(),
+	}
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.position
 	for isLetter(l.ch) {
@@ -143,24 +297,242 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readInteger() string {
+// numberBases describes the integer literals with a base prefix.
+var numberBases = map[rune]struct {
+	name    string
+	isDigit func(rune) bool
+}{
+	'x': {"hexadecimal", isHexDigit},
+	'o': {"octal", func(char rune) bool { return '0' <= char && char <= '7' }},
+	'b': {"binary", func(char rune) bool { return char == '0' || char == '1' }},
+}
+
+// readNumber reads an integer or a float literal. A fraction must have
+// digits after the dot, otherwise the dot is left for the next token, and
+// an exponent must have digits after its optional sign. Malformed
+// literals, including those directly followed by a letter or a digit, are
+// ILLEGAL.
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	position := l.position
-	for isDigits(l.ch) {
+	if _, ok := numberBases[unicode.ToLower(l.peekChar())]; ok && l.ch == '0' {
+		return l.readPrefixedInteger()
+	}
+
+	tokenType := token.TokenType(token.INT)
+	ok := l.readDigits(isDigits, false)
+
+	if l.ch == '.' && isDigits(l.peekChar()) {
+		tokenType = token.FLOAT
 		l.readChar()
+		ok = l.readDigits(isDigits, false) && ok
 	}
 
-	return l.input[position:l.position]
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if next == '+' || next == '-' {
+			next = l.peekCharAt(2)
+		}
+
+		if isDigits(next) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			ok = l.readDigits(isDigits, false) && ok
+		} else if ok {
+			l.addError(l.currentPosition(), "exponent has no digits")
+			ok = false
+		}
+	}
+
+	name := "decimal"
+	literal := l.input[position:l.position]
+	if tokenType == token.INT && len(literal) > 1 && literal[0] == '0' {
+		// Like in Go, a leading zero makes an octal literal.
+		name = "octal"
+		if i := strings.IndexAny(literal, "89"); ok && i >= 0 {
+			pos := l.currentPosition()
+			pos.Offset, pos.Column = position+i, pos.Column-(len(literal)-i)
+			l.addError(pos, "invalid digit %q in octal literal", literal[i])
+			ok = false
+		}
+	}
+
+	ok = l.readNumberSuffix(name, ok)
+	literal = l.input[position:l.position]
+	if !ok {
+		tokenType = token.ILLEGAL
+	}
+	return tokenType, literal
 }
 
-func isLetter(char byte) bool {
-	return 'a' <= char && char <= 'z' || 'A' <= char && char <= 'Z' || char == '_'
+// readPrefixedInteger reads an integer literal starting with 0x, 0o or 0b.
+func (l *Lexer) readPrefixedInteger() (token.TokenType, string) {
+	start := l.currentPosition()
+	l.readChar()
+	base := numberBases[unicode.ToLower(l.ch)]
+	l.readChar()
+
+	ok := l.readDigits(base.isDigit, true)
+	ok = l.readNumberSuffix(base.name, ok)
+	if ok && l.input[start.Offset+2:l.position] == "" {
+		l.addError(start, "%s literal has no digits", base.name)
+		ok = false
+	}
+
+	if !ok {
+		return token.ILLEGAL, l.input[start.Offset:l.position]
+	}
+	return token.INT, l.input[start.Offset:l.position]
 }
 
-func isDigits(char byte) bool {
+// readNumberSuffix reads the letters and digits directly following a number
+// literal, which make it malformed rather than start another token, and
+// reports whether the literal is still well-formed.
+func (l *Lexer) readNumberSuffix(name string, ok bool) bool {
+	if ok && (isLetter(l.ch) || isDigits(l.ch)) {
+		l.addError(l.currentPosition(), "invalid digit %q in %s literal", l.ch, name)
+		ok = false
+	}
+	for isLetter(l.ch) || isDigits(l.ch) {
+		l.readChar()
+	}
+
+	return ok
+}
+
+// readDigits reads digits accepted by isDigit, which may be separated by
+// single underscores, and reports whether they are well-formed. An
+// underscore may also follow a base prefix, as in 0x_FF.
+func (l *Lexer) readDigits(isDigit func(rune) bool, afterPrefix bool) bool {
+	ok := true
+	afterDigit := afterPrefix
+	for isDigit(l.ch) || l.ch == '_' {
+		if l.ch == '_' {
+			if ok && (!afterDigit || !isDigit(l.peekChar())) {
+				l.addError(l.currentPosition(), "'_' must separate successive digits")
+				ok = false
+			}
+			afterDigit = false
+		} else {
+			afterDigit = true
+		}
+		l.readChar()
+	}
+
+	return ok
+}
+
+// readString reads a double-quoted string literal and returns its value
+// with escape sequences resolved. It stops at the closing quote, or at the
+// end of input when the literal is unterminated. Malformed escape sequences
+// and invalid UTF-8 are reported without stopping, and make it return
+// false.
+func (l *Lexer) readString() (string, bool) {
+	start := l.currentPosition()
+	ok := true
+
+	var out strings.Builder
+	for {
+		l.readChar()
+
+		switch {
+		case l.isAtEnd():
+			l.errors = append(l.errors, &Error{Pos: start, Message: "unterminated string literal", Unterminated: true})
+			return "", false
+		case l.ch == '"':
+			return out.String(), ok
+		case l.ch == '\\':
+			if !l.readEscape(&out) {
+				ok = false
+			}
+		case !l.checkEncoding():
+			ok = false
+		default:
+			// Copy the source bytes, so the literal is exactly as written.
+			out.WriteString(l.input[l.position:l.readPosition])
+		}
+	}
+}
+
+// readEscape reads the escape sequence starting at the backslash in ch into
+// out, leaving ch at its last character. A backslash ending the input is
+// left in ch, for readString to report the literal unterminated.
+func (l *Lexer) readEscape(out *strings.Builder) bool {
+	start := l.currentPosition()
+	if l.readPosition >= len(l.input) {
+		return false
+	}
+	l.readChar()
+
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '"':
+		out.WriteByte('"')
+	case '\\':
+		out.WriteByte('\\')
+	case 'u':
+		return l.readUnicodeEscape(start, out)
+	default:
+		l.addError(start, "unknown escape sequence \\%c", l.ch)
+		return false
+	}
+
+	return true
+}
+
+// readUnicodeEscape reads the "{...}" part of a \u{...} escape sequence.
+func (l *Lexer) readUnicodeEscape(start token.Position, out *strings.Builder) bool {
+	if l.peekChar() != '{' {
+		l.addError(start, "\\u must be followed by {hex digits}")
+		return false
+	}
+	l.readChar()
+
+	position := l.position + 1
+	for isHexDigit(l.peekChar()) {
+		l.readChar()
+	}
+	digits := l.input[position : l.position+1]
+
+	if l.peekChar() != '}' || len(digits) == 0 || len(digits) > 6 {
+		l.addError(start, "\\u{...} must contain 1 to 6 hex digits")
+		return false
+	}
+	l.readChar()
+
+	code, _ := strconv.ParseUint(digits, 16, 32)
+	if !utf8.ValidRune(rune(code)) {
+		l.addError(start, "invalid Unicode code point U+%X", code)
+		return false
+	}
+	out.WriteRune(rune(code))
+
+	return true
+}
+
+func isHexDigit(char rune) bool {
+	return isDigits(char) || 'a' <= char && char <= 'f' || 'A' <= char && char <= 'F'
+}
+
+// isLetter reports whether char can be part of an identifier, which may
+// use the letters of any script.
+func isLetter(char rune) bool {
+	return 'a' <= char && char <= 'z' || 'A' <= char && char <= 'Z' || char == '_' ||
+		char >= utf8.RuneSelf && unicode.IsLetter(char)
+}
+
+func isDigits(char rune) bool {
 	return '0' <= char && char <= '9'
 }
 
-func newTokenWithChar(tokenType token.TokenType, char byte) token.Token {
+func newTokenWithChar(tokenType token.TokenType, char rune) token.Token {
 	return newToken(tokenType, string(char))
 }
 
@@ -168,22 +540,67 @@ func newToken(tokenType token.TokenType, literal string) token.Token {
 	return token.Token{Type: tokenType, Literal: literal}
 }
 
-func (l *Lexer) peekChar() byte {
-	// TODO: Support Unicode
-	if l.readPosition >= len(l.input) {
+func (l *Lexer) peekChar() rune {
+	return l.peekCharAt(1)
+}
+
+// peekCharAt returns the character offset characters after the current one.
+func (l *Lexer) peekCharAt(offset int) rune {
+	position := l.readPosition
+	for ; offset > 1 && position < len(l.input); offset-- {
+		_, width := utf8.DecodeRuneInString(l.input[position:])
+		position += width
+	}
+
+	if position >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	char, _ := utf8.DecodeRuneInString(l.input[position:])
+	return char
 }
 
 func (l *Lexer) readChar() {
-	// TODO: Support Unicode
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
+
+	l.position = l.readPosition
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
+		l.readPosition += 1
 	} else {
-		l.ch = l.input[l.readPosition]
+		var width int
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
+		l.readPosition += width
+	}
+	l.column += 1
+}
+
+func (l *Lexer) isAtEnd() bool {
+	return l.position >= len(l.input)
+}
+
+// checkEncoding reports whether ch was decoded from valid UTF-8, recording
+// an error otherwise. Every invalid byte counts as one character.
+func (l *Lexer) checkEncoding() bool {
+	if l.ch != utf8.RuneError || l.readPosition-l.position != 1 {
+		return true
+	}
+
+	l.addError(l.currentPosition(), "invalid UTF-8 encoding")
+	return false
+}
+
+func (l *Lexer) addError(pos token.Position, format string, args ...interface{}) {
+	l.errors = append(l.errors, &Error{Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
 	}
-	l.position = l.readPosition
-	l.readPosition += 1
 }
@@ -12,7 +12,7 @@ let add = fn(x, y) {
   x + y;
 };
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 if (5 < 10) {
   return true;
@@ -20,6 +20,10 @@ if (5 < 10) {
   return false;
 }
 return 1|2 == 2 && 1 >= 2 || 2 <= 3 && 2&0 != 3;
+[1, 2][0];
+{"a": 1}
+a % b ** c ^ ~d << 1 >> 2 * e
+null nullable
 `
 
 	tests := []struct {
@@ -118,6 +122,36 @@ return 1|2 == 2 && 1 >= 2 || 2 <= 3 && 2&0 != 3;
 		{token.NEQ, "!="},
 		{token.INT, "3"},
 		{token.SEMICOLON, ";"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACKET, "]"},
+		{token.LBRACKET, "["},
+		{token.INT, "0"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.LBRACE, "{"},
+		{token.STRING, "a"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.IDENT, "a"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "b"},
+		{token.POWER, "**"},
+		{token.IDENT, "c"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.IDENT, "d"},
+		{token.SHL, "<<"},
+		{token.INT, "1"},
+		{token.SHR, ">>"},
+		{token.INT, "2"},
+		{token.ASTERISK, "*"},
+		{token.IDENT, "e"},
+		{token.NULL, "null"},
+		{token.IDENT, "nullable"},
 		{token.EOF, ""},
 	}
 
@@ -134,3 +168,354 @@ return 1|2 == 2 && 1 >= 2 || 2 <= 3 && 2&0 != 3;
 		}
 	}
 }
+
+func TestNextTokenPosition(t *testing.T) {
+	input := `let x = 10;
+  x >= 5`
+
+	tests := []struct {
+		expectedType  token.TokenType
+		expectedStart token.Position
+		expectedEnd   token.Position
+	}{
+		{token.LET, token.Position{Filename: "test.mk", Offset: 0, Line: 1, Column: 1}, token.Position{Filename: "test.mk", Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, token.Position{Filename: "test.mk", Offset: 4, Line: 1, Column: 5}, token.Position{Filename: "test.mk", Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Position{Filename: "test.mk", Offset: 6, Line: 1, Column: 7}, token.Position{Filename: "test.mk", Offset: 7, Line: 1, Column: 8}},
+		{token.INT, token.Position{Filename: "test.mk", Offset: 8, Line: 1, Column: 9}, token.Position{Filename: "test.mk", Offset: 10, Line: 1, Column: 11}},
+		{token.SEMICOLON, token.Position{Filename: "test.mk", Offset: 10, Line: 1, Column: 11}, token.Position{Filename: "test.mk", Offset: 11, Line: 1, Column: 12}},
+		{token.IDENT, token.Position{Filename: "test.mk", Offset: 14, Line: 2, Column: 3}, token.Position{Filename: "test.mk", Offset: 15, Line: 2, Column: 4}},
+		{token.GTE, token.Position{Filename: "test.mk", Offset: 16, Line: 2, Column: 5}, token.Position{Filename: "test.mk", Offset: 18, Line: 2, Column: 7}},
+		{token.INT, token.Position{Filename: "test.mk", Offset: 19, Line: 2, Column: 8}, token.Position{Filename: "test.mk", Offset: 20, Line: 2, Column: 9}},
+		{token.EOF, token.Position{Filename: "test.mk", Offset: 20, Line: 2, Column: 9}, token.Position{Filename: "test.mk", Offset: 20, Line: 2, Column: 9}},
+	}
+
+	l := NewFile("test.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - Token.Type is wrong. (%q != %q) (expected != actual)", i, tt.expectedType, tok.Type)
+		}
+		if tok.Start != tt.expectedStart {
+			t.Fatalf("tests[%d] - Token.Start is wrong. (%+v != %+v) (expected != actual)", i, tt.expectedStart, tok.Start)
+		}
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("tests[%d] - Token.End is wrong. (%+v != %+v) (expected != actual)", i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestNextTokenPositionUnterminated(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedType  token.TokenType
+		expectedStart token.Position
+		expectedEnd   token.Position
+	}{
+		{`"abc\`, token.ILLEGAL, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{`"abc\`, token.EOF, token.Position{Offset: 5, Line: 1, Column: 6}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{`"abc`, token.ILLEGAL, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 4, Line: 1, Column: 5}},
+		{`"abc`, token.EOF, token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 4, Line: 1, Column: 5}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		tok := l.NextToken()
+		if tt.expectedType == token.EOF {
+			tok = l.NextToken()
+		}
+		if tok.Type != tt.expectedType {
+			t.Fatalf("Expected %q token from %s but %q", tt.expectedType, tt.input, tok.Type)
+		}
+		if tok.Start != tt.expectedStart {
+			t.Fatalf("Expected %q token from %s to start at %+v but %+v", tt.expectedType, tt.input, tt.expectedStart, tok.Start)
+		}
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("Expected %q token from %s to end at %+v but %+v", tt.expectedType, tt.input, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestNextTokenString(t *testing.T) {
+	input := `"foobar" "foo bar" "a\nb\t\"c\"\\" "\u{D55C}\u{AD6D}" "";`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "foobar"},
+		{token.STRING, "foo bar"},
+		{token.STRING, "a\nb\t\"c\"\\"},
+		{token.STRING, "한국"},
+		{token.STRING, ""},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - Token.Type is wrong. (%q != %q) (expected != actual)", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Token.Literal is wrong. (%q != %q) (expected != actual)", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Fatalf("Expected no errors but %v", l.Errors())
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let 이름 = \"한국어 e\u0301\";\n이름 + _x日本"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedOffset  int
+		expectedColumn  int
+	}{
+		{token.LET, "let", 0, 1},
+		{token.IDENT, "이름", 4, 5},
+		{token.ASSIGN, "=", 11, 8},
+		// Strings keep the combining accent as written.
+		{token.STRING, "한국어 e\u0301", 13, 10},
+		{token.SEMICOLON, ";", 28, 18},
+		{token.IDENT, "이름", 30, 1},
+		{token.PLUS, "+", 37, 4},
+		{token.IDENT, "_x日本", 39, 6},
+		{token.EOF, "", 47, 10},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Expected %s %q but %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Start.Offset != tt.expectedOffset || tok.Start.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - Expected %q at offset %d, column %d but %d, %d",
+				i, tt.expectedLiteral, tt.expectedOffset, tt.expectedColumn, tok.Start.Offset, tok.Start.Column)
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Fatalf("Expected no errors but %v", l.Errors())
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedError   string
+	}{
+		{"\xff", "\xff", "1:1: invalid UTF-8 encoding"},
+		{"\xe4\xbd", "\xe4", "1:1: invalid UTF-8 encoding"},
+		{"\"한\xffb\"", "\"한\xffb\"", "1:3: invalid UTF-8 encoding"},
+		{"// \xff\n", "// \xff", "1:4: invalid UTF-8 encoding"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		tok := l.NextToken()
+		if tok.Type != token.ILLEGAL || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("Expected ILLEGAL %q from %q but %s %q", tt.expectedLiteral, tt.input, tok.Type, tok.Literal)
+		}
+
+		errors := l.Errors()
+		if len(errors) == 0 || errors[0].Error() != tt.expectedError {
+			t.Fatalf("Expected error '%s' from %q but %v", tt.expectedError, tt.input, errors)
+		}
+	}
+}
+
+func TestNextTokenNumber(t *testing.T) {
+	input := `5 3.14 0.5 1e9 1e-9 2.5E+3 1. 1.e 2.x
+0xFF 0X_7f 0o17 0b1010_0101 1_000_000 0755 1_000.000_1e1_0 0xFF+1`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "e"},
+		{token.INT, "2"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "x"},
+		{token.INT, "0xFF"},
+		{token.INT, "0X_7f"},
+		{token.INT, "0o17"},
+		{token.INT, "0b1010_0101"},
+		{token.INT, "1_000_000"},
+		{token.INT, "0755"},
+		{token.FLOAT, "1_000.000_1e1_0"},
+		{token.INT, "0xFF"},
+		{token.PLUS, "+"},
+		{token.INT, "1"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - Token.Type is wrong. (%q != %q) (expected != actual)", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Token.Literal is wrong. (%q != %q) (expected != actual)", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextTokenIllegalNumber(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedError   string
+	}{
+		{"0x", "0x", "1:1: hexadecimal literal has no digits"},
+		{"0b;", "0b", "1:1: binary literal has no digits"},
+		{"0b2", "0b2", "1:3: invalid digit '2' in binary literal"},
+		{"0b1012", "0b1012", "1:6: invalid digit '2' in binary literal"},
+		{"0o78_9", "0o78_9", "1:4: invalid digit '8' in octal literal"},
+		{"0128", "0128", "1:4: invalid digit '8' in octal literal"},
+		{"1__000", "1__000", "1:2: '_' must separate successive digits"},
+		{"1000_", "1000_", "1:5: '_' must separate successive digits"},
+		{"0x_", "0x_", "1:3: '_' must separate successive digits"},
+		{"1_.5", "1_.5", "1:2: '_' must separate successive digits"},
+		{"1.5_e3", "1.5_e3", "1:4: '_' must separate successive digits"},
+		// Letters and digits directly after a literal are part of it.
+		{"0xFFg", "0xFFg", "1:5: invalid digit 'g' in hexadecimal literal"},
+		{"0b1a", "0b1a", "1:4: invalid digit 'a' in binary literal"},
+		{"0b1x;", "0b1x", "1:4: invalid digit 'x' in binary literal"},
+		{"123abc", "123abc", "1:4: invalid digit 'a' in decimal literal"},
+		{"0755_9a", "0755_9a", "1:6: invalid digit '9' in octal literal"},
+		{"0x1p4", "0x1p4", "1:4: invalid digit 'p' in hexadecimal literal"},
+		{"1.5x", "1.5x", "1:4: invalid digit 'x' in decimal literal"},
+		{"1.5e", "1.5e", "1:4: exponent has no digits"},
+		{"2e+)", "2e", "1:2: exponent has no digits"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		tok := l.NextToken()
+		if tok.Type != token.ILLEGAL || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("Expected ILLEGAL %q from %q but %s %q", tt.expectedLiteral, tt.input, tok.Type, tok.Literal)
+		}
+
+		errors := l.Errors()
+		if len(errors) != 1 || errors[0].Error() != tt.expectedError {
+			t.Fatalf("Expected error '%s' from %q but %v", tt.expectedError, tt.input, errors)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 1; // trailing
+/* block
+   comment */ x /**/ / 2 /* a * b / c */
+//`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{token.COMMENT, "// leading", 1, 1},
+		{token.LET, "let", 2, 1},
+		{token.IDENT, "x", 2, 5},
+		{token.ASSIGN, "=", 2, 7},
+		{token.INT, "1", 2, 9},
+		{token.SEMICOLON, ";", 2, 10},
+		{token.COMMENT, "// trailing", 2, 12},
+		{token.COMMENT, "/* block\n   comment */", 3, 1},
+		{token.IDENT, "x", 4, 15},
+		{token.COMMENT, "/**/", 4, 17},
+		{token.SLASH, "/", 4, 22},
+		{token.INT, "2", 4, 24},
+		{token.COMMENT, "/* a * b / c */", 4, 26},
+		{token.COMMENT, "//", 5, 1},
+		{token.EOF, "", 5, 3},
+	}
+
+	for _, keep := range []bool{true, false} {
+		l := New(input)
+		l.KeepComments(keep)
+
+		for i, tt := range tests {
+			if tt.expectedType == token.COMMENT && !keep {
+				continue
+			}
+
+			tok := l.NextToken()
+			if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+				t.Fatalf("tests[%d] - Expected %s %q but %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+			}
+			if tok.Start.Line != tt.expectedLine || tok.Start.Column != tt.expectedColumn {
+				t.Fatalf("tests[%d] - Expected %q at %d:%d but %s", i, tt.expectedLiteral, tt.expectedLine, tt.expectedColumn, tok.Start)
+			}
+		}
+		if len(l.Errors()) != 0 {
+			t.Fatalf("Expected no errors but %v", l.Errors())
+		}
+	}
+}
+
+func TestNextTokenIllegalString(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedErrors  []string
+	}{
+		{`"abc`, `"abc`, []string{"1:1: unterminated string literal"}},
+		{`"abc\`, `"abc\`, []string{"1:1: unterminated string literal"}},
+		{`"a\qc" 1`, `"a\qc"`, []string{`1:3: unknown escape sequence \q`}},
+		{`"\u{}"`, `"\u{}"`, []string{"1:2: \\u{...} must contain 1 to 6 hex digits"}},
+		{`"\u0041"`, `"\u0041"`, []string{"1:2: \\u must be followed by {hex digits}"}},
+		{`"\u{D800}"`, `"\u{D800}"`, []string{"1:2: invalid Unicode code point U+D800"}},
+		{"/* a\n * b", "/* a\n * b", []string{"1:1: unterminated comment"}},
+		{"/*/", "/*/", []string{"1:1: unterminated comment"}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		tok := l.NextToken()
+		if tok.Type != token.ILLEGAL {
+			t.Fatalf("Expected ILLEGAL token from %s but %q", tt.input, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("Expected literal %q but %q", tt.expectedLiteral, tok.Literal)
+		}
+
+		errors := l.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Fatalf("Expected %d errors from %s but %v", len(tt.expectedErrors), tt.input, errors)
+		}
+		for i, expected := range tt.expectedErrors {
+			if errors[i].Error() != expected {
+				t.Fatalf("Expected error '%s' but '%s'", expected, errors[i].Error())
+			}
+		}
+	}
+}
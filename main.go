@@ -1,18 +1,169 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"github.com/moreal/monkey/ast"
+	"github.com/moreal/monkey/compiler"
+	"github.com/moreal/monkey/evaluator"
+	"github.com/moreal/monkey/lexer"
+	"github.com/moreal/monkey/object"
+	"github.com/moreal/monkey/parser"
 	"github.com/moreal/monkey/repl"
+	"github.com/moreal/monkey/vm"
+	"io"
 	"os"
 	"os/user"
 )
 
+const usage = `Usage:
+  monkey                  start the REPL
+  monkey [run] FILE       run a Monkey source file
+  monkey -e EXPRESSION    evaluate an expression and print its value
+
+Flags:
+`
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command line args, reading the REPL input from stdin, and
+// returns the process exit code: 2 for bad usage, 1 when the program has
+// syntax errors or raises a runtime error, and 0 otherwise.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("monkey", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	expression := flags.String("e", "", "evaluate `expression` and print its value")
+	engine := flags.String("engine", "eval", "run programs with `engine`: eval (tree-walking) or vm (bytecode)")
+	checked := flags.Bool("checked", false, "report integer results and literals beyond 64 bits as errors instead of promoting them to big integers")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	if *engine != "eval" && *engine != "vm" {
+		fmt.Fprintf(stderr, "unknown engine %q\n", *engine)
+		flags.Usage()
+		return 2
+	}
+
+	// An empty -e is an empty program, not a request for the REPL.
+	hasExpression := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "e" {
+			hasExpression = true
+		}
+	})
+
+	args = flags.Args()
+	if len(args) > 0 && args[0] == "run" {
+		args = args[1:]
+		if len(args) == 0 {
+			flags.Usage()
+			return 2
+		}
+	}
+
+	defer func(output io.Writer) { evaluator.Output = output }(evaluator.Output)
+	evaluator.Output = stdout
+
+	switch {
+	case hasExpression:
+		if len(args) != 0 {
+			flags.Usage()
+			return 2
+		}
+		return evaluate(*engine, *checked, "-e", *expression, true, stdout, stderr)
+	case len(args) == 1:
+		source, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return evaluate(*engine, *checked, args[0], string(source), false, stdout, stderr)
+	case len(args) > 1:
+		flags.Usage()
+		return 2
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Fprintf(os.Stderr, "Hello %s,! This is the Monkey programming language! >=<\n", user.Username)
-	repl.Start(os.Stdin, os.Stdout, os.Stderr)
+	fmt.Fprintf(stderr, "Hello %s,! This is the Monkey programming language! >=<\n", user.Username)
+	if *engine == "vm" {
+		repl.StartVM(stdin, stdout, stderr, *checked)
+	} else {
+		repl.Start(stdin, stdout, stderr, *checked)
+	}
+	return 0
+}
+
+// evaluate evaluates source with engine, with checked arithmetic if checked
+// is set, and returns the process exit code, which is non-zero when source
+// has syntax errors or raises a runtime error. Errors are written to stderr
+// and, if printResult is set, the value of source to stdout.
+func evaluate(engine string, checked bool, filename, source string, printResult bool, stdout, stderr io.Writer) int {
+	l := lexer.NewFile(filename, source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, err := range p.Errors() {
+			fmt.Fprintln(stderr, err.Error())
+		}
+		return 1
+	}
+
+	var evaluated object.Object
+	if engine == "vm" {
+		evaluated = execute(program, checked)
+	} else {
+		env := object.NewEnvironment()
+		env.SetCheckedArithmetic(checked)
+		evaluated = evaluator.Eval(program, env)
+	}
+
+	if errObj, ok := evaluated.(*object.Error); ok {
+		fmt.Fprintln(stderr, errObj.Inspect())
+		return 1
+	}
+
+	if printResult && evaluated != evaluator.NULL {
+		fmt.Fprintln(stdout, evaluated.Inspect())
+	}
+
+	return 0
+}
+
+// execute compiles program to bytecode and runs it, returning the value of
+// the last expression statement or the error that stopped it.
+func execute(program *ast.Program, checked bool) object.Object {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return toError(err)
+	}
+
+	machine := vm.New(comp.Bytecode())
+	machine.SetCheckedArithmetic(checked)
+	if err := machine.Run(); err != nil {
+		return toError(err)
+	}
+
+	return machine.LastPoppedStackElem()
+}
+
+func toError(err error) *object.Error {
+	if errObj, ok := err.(*object.Error); ok {
+		return errObj
+	}
+	return &object.Error{Message: err.Error()}
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ok.mk":      "puts(\"hi\")\n1 + 2\n",
+		"syntax.mk":  "let x = ;\n",
+		"runtime.mk": "puts(1)\n1 + true\n",
+	}
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{path("ok.mk")}, 0, "hi\n", ""},
+		{[]string{"run", path("ok.mk")}, 0, "hi\n", ""},
+		{[]string{path("syntax.mk")}, 1, "", path("syntax.mk") + ":1:9: no prefix parse function for ; found\n"},
+		{[]string{"run", path("runtime.mk")}, 1, "1\n", "ERROR: " + path("runtime.mk") + ":2:1: type mismatch: INTEGER + BOOLEAN\n"},
+		{[]string{path("missing.mk")}, 1, "", "open " + path("missing.mk") + ": no such file or directory\n"},
+		{[]string{"-e", "puts(2); 3"}, 0, "2\n3\n", ""},
+		{[]string{"-e", "puts(2)"}, 0, "2\n", ""},
+		{[]string{"-e", ""}, 0, "", ""},
+		{[]string{"-e", "let x = ;"}, 1, "", "-e:1:9: no prefix parse function for ; found\n"},
+		{[]string{"-e", "1 / 0"}, 1, "", "ERROR: -e:1:1: division by zero\n"},
+	}
+
+	for _, engine := range []string{"eval", "vm"} {
+		for _, tt := range tests {
+			args := append([]string{"-engine", engine}, tt.args...)
+			var stdout, stderr bytes.Buffer
+			code := run(args, strings.NewReader(""), &stdout, &stderr)
+
+			if code != tt.code {
+				t.Errorf("%s: Expected exit code %d for %q but %d", engine, tt.code, tt.args, code)
+			}
+			if stdout.String() != tt.stdout {
+				t.Errorf("%s: Expected output %q for %q but %q", engine, tt.stdout, tt.args, stdout.String())
+			}
+			if stderr.String() != tt.stderr {
+				t.Errorf("%s: Expected errors %q for %q but %q", engine, tt.stderr, tt.args, stderr.String())
+			}
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := [][]string{
+		{"run"},
+		{"a.mk", "b.mk"},
+		{"-e", "1", "a.mk"},
+		{"-engine", "jit", "-e", "1"},
+		{"-unknown"},
+	}
+
+	for _, args := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := run(args, strings.NewReader(""), &stdout, &stderr); code != 2 {
+			t.Errorf("Expected exit code 2 for %q but %d", args, code)
+		}
+		if !strings.Contains(stderr.String(), "Usage:") {
+			t.Errorf("Expected usage for %q but %q", args, stderr.String())
+		}
+	}
+}
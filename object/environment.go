@@ -3,11 +3,30 @@ package object
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	// callDepth is the number of function calls evaluated to get to the
+	// environment, and depth the number of nested evaluations in progress
+	// in it, including the ones of its callers.
+	callDepth int
+	depth     int
+
+	checked bool
+}
+
+// NewCallEnvironment returns the environment of a function call from the
+// environment caller, enclosed by outer, the environment of the function.
+func NewCallEnvironment(outer, caller *Environment) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.callDepth = caller.callDepth + 1
+	env.depth = caller.depth
+	env.checked = caller.checked
+	return env
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.checked = outer.checked
 	return env
 }
 
@@ -15,6 +34,40 @@ func NewEnvironment() *Environment {
 	return &Environment{store: make(map[string]Object), outer: nil}
 }
 
+// SetCheckedArithmetic makes integer operations evaluated in e, and in the
+// environments enclosed by it, fail with an error when their result or an
+// integer literal does not fit in an int64. By default such integers are
+// big integers.
+func (e *Environment) SetCheckedArithmetic(checked bool) {
+	e.checked = checked
+}
+
+// CheckedArithmetic reports whether integer arithmetic is checked in e.
+func (e *Environment) CheckedArithmetic() bool {
+	return e.checked
+}
+
+// CallDepth returns the number of function calls being evaluated in env.
+func (e *Environment) CallDepth() int {
+	return e.callDepth
+}
+
+// Enter records the start of an evaluation in e and returns the number of
+// nested evaluations in progress. Leave records its end.
+func (e *Environment) Enter() int {
+	e.depth++
+	return e.depth
+}
+
+func (e *Environment) Leave() {
+	e.depth--
+}
+
+// Reset forgets the evaluations in progress in e, after they were abandoned.
+func (e *Environment) Reset() {
+	e.depth = 0
+}
+
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
 	if !ok && e.outer != nil {
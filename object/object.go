@@ -4,7 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/moreal/monkey/ast"
+	"github.com/moreal/monkey/code"
 	"github.com/moreal/monkey/token"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -12,11 +18,16 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
+	STRING_OBJ       = "STRING"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
+	BUILTIN_OBJ      = "BUILTIN"
+	ARRAY_OBJ        = "ARRAY"
+	HASH_OBJ         = "HASH"
 )
 
 type Object interface {
@@ -24,6 +35,18 @@ type Object interface {
 	Inspect() string
 }
 
+// HashKey identifies a hashable object by its type and value, so that
+// equal objects map to the same hash entry.
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable is implemented by objects usable as hash keys.
+type Hashable interface {
+	HashKey() HashKey
+}
+
 type Integer struct {
 	Value int64
 }
@@ -34,6 +57,65 @@ func (*Integer) Type() ObjectType {
 func (i *Integer) Inspect() string {
 	return fmt.Sprintf("%d", i.Value)
 }
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+// BigInteger is an integer which does not fit in an int64. It reports the
+// same type as Integer, and NewInteger makes sure every integer has a single
+// representation, so programs cannot tell them apart.
+type BigInteger struct {
+	Value *big.Int
+}
+
+// bigIntegerKey distinguishes the hash keys of BigIntegers from the ones of
+// Integers, which use all the bits of HashKey.Value.
+const bigIntegerKey ObjectType = "BIG_INTEGER"
+
+func (*BigInteger) Type() ObjectType {
+	return INTEGER_OBJ
+}
+func (bi *BigInteger) Inspect() string {
+	return bi.Value.String()
+}
+func (bi *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(bi.Value.String()))
+	return HashKey{Type: bigIntegerKey, Value: h.Sum64()}
+}
+
+// NewInteger returns value as an Integer when it fits in an int64, and as a
+// BigInteger otherwise. value must not be modified afterwards.
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInteger{Value: value}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (*Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
+// Inspect formats f with the fewest digits which read back as the same
+// value, using exponent notation for very small and large magnitudes, and
+// always shows that it is a float: 2.0, 0.1, 1e-09, 1e+21.
+func (f *Float) Inspect() string {
+	format := byte('f')
+	if abs := math.Abs(f.Value); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+
+	s := strconv.FormatFloat(f.Value, format, -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
 
 type Boolean struct {
 	Value bool
@@ -45,6 +127,29 @@ func (*Boolean) Type() ObjectType {
 func (b *Boolean) Inspect() string {
 	return fmt.Sprintf("%t", b.Value)
 }
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+type String struct {
+	Value string
+}
+
+func (*String) Type() ObjectType {
+	return STRING_OBJ
+}
+func (s *String) Inspect() string {
+	return s.Value
+}
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
 
 type Null struct{}
 
@@ -66,19 +171,32 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
+// Error is a runtime error. Pos is where it was raised, when known.
+// It also implements the error interface for Go callers.
 type Error struct {
 	Message string
+	Pos     token.Position
+}
+
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+	}
+	return e.Message
 }
 
 func (*Error) Type() ObjectType {
 	return ERROR_OBJ
 }
 func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("ERROR: %s: %s", e.Pos, e.Message)
+	}
 	return fmt.Sprintf("ERROR: %s", e.Message)
 }
 
 type Function struct {
-	Parameters []*ast.Identifier
+	Parameters []*ast.Parameter
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -103,3 +221,134 @@ func (f *Function) Inspect() string {
 
 	return out.String()
 }
+
+// CompiledFunction is a function compiled to bytecode. It reports the same
+// type as Function, so programs cannot tell them apart.
+type CompiledFunction struct {
+	Instructions code.Instructions
+	NumLocals    int
+	// NumParameters counts the parameters but the rest one, NumRequired the
+	// ones without a default value. Variadic is set when there is a rest
+	// parameter, which comes right after the others.
+	NumParameters int
+	NumRequired   int
+	Variadic      bool
+	// Parameters are the parameters as written, for display, and
+	// ParameterNames the names of the first NumParameters of them, which
+	// named arguments bind to.
+	Parameters     []string
+	ParameterNames []string
+	// LocalNames are the names of the locals, for reporting reads of the
+	// ones which were never set.
+	LocalNames []string
+	// FreeVariables are the variables of the enclosing function which the
+	// closures of this one capture. Captured is set when closures created
+	// by this function capture some of its own locals, which must then
+	// outlive its calls.
+	FreeVariables []FreeVariable
+	Captured      bool
+	// Positions maps instruction offsets to the source position of the
+	// expression they were compiled from, for locating runtime errors.
+	Positions map[int]token.Position
+}
+
+func (*CompiledFunction) Type() ObjectType {
+	return FUNCTION_OBJ
+}
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("fn(%s) { <compiled> }", strings.Join(cf.Parameters, ", "))
+}
+
+// FreeVariable is a variable a closure captures from the function creating
+// it: the local of that function at Index, or its free variable at Index
+// when Local is not set.
+type FreeVariable struct {
+	Name  string
+	Local bool
+	Index int
+}
+
+// Closure is a CompiledFunction together with the free variables it
+// captured when it was created. They are shared with the function which
+// created it, as environments are shared by Eval, so that both see later
+// assignments of one another. A nil variable was never set.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Object
+}
+
+func (*Closure) Type() ObjectType {
+	return FUNCTION_OBJ
+}
+func (c *Closure) Inspect() string {
+	return c.Fn.Inspect()
+}
+
+// BuiltinFunction is a host function callable from Monkey code.
+type BuiltinFunction func(args ...Object) Object
+
+// Builtin is a builtin function. Named arguments of a call are passed to
+// Fn at the position of their name in Parameters, which may be left empty
+// for builtins not taking any.
+type Builtin struct {
+	Name       string
+	Fn         BuiltinFunction
+	Parameters []string
+}
+
+func (*Builtin) Type() ObjectType {
+	return BUILTIN_OBJ
+}
+func (b *Builtin) Inspect() string {
+	return fmt.Sprintf("builtin function %s", b.Name)
+}
+
+type Array struct {
+	Elements []Object
+}
+
+func (*Array) Type() ObjectType {
+	return ARRAY_OBJ
+}
+func (a *Array) Inspect() string {
+	var out bytes.Buffer
+
+	var elements []string
+	for _, e := range a.Elements {
+		elements = append(elements, e.Inspect())
+	}
+
+	out.WriteString(token.LBRACKET)
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString(token.RBRACKET)
+
+	return out.String()
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+func (*Hash) Type() ObjectType {
+	return HASH_OBJ
+}
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+
+	var pairs []string
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	}
+	sort.Strings(pairs)
+
+	out.WriteString(token.LBRACE)
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString(token.RBRACE)
+
+	return out.String()
+}
@@ -0,0 +1,73 @@
+package object
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestHashKeys(t *testing.T) {
+	hello1 := &String{Value: "Hello World"}
+	hello2 := &String{Value: "Hello World"}
+	diff := &String{Value: "My name is johnny"}
+
+	if hello1.HashKey() != hello2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+
+	if hello1.HashKey() == diff.HashKey() {
+		t.Errorf("strings with different content have same hash keys")
+	}
+
+	one := &Integer{Value: 1}
+	if one.HashKey() == (&Boolean{Value: true}).HashKey() {
+		t.Errorf("1 and true have same hash keys")
+	}
+}
+
+func TestNewInteger(t *testing.T) {
+	small := NewInteger(big.NewInt(42))
+	if integer, ok := small.(*Integer); !ok || integer.Value != 42 {
+		t.Errorf("Expected Integer 42 but %T %s", small, small.Inspect())
+	}
+
+	value, _ := new(big.Int).SetString("9223372036854775808", 10)
+	large := NewInteger(value)
+	if _, ok := large.(*BigInteger); !ok || large.Inspect() != "9223372036854775808" {
+		t.Errorf("Expected BigInteger 9223372036854775808 but %T %s", large, large.Inspect())
+	}
+	if large.Type() != INTEGER_OBJ {
+		t.Errorf("Expected BigInteger to be %s but %s", INTEGER_OBJ, large.Type())
+	}
+
+	same, _ := new(big.Int).SetString("9223372036854775808", 10)
+	if large.(Hashable).HashKey() != NewInteger(same).(Hashable).HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+	if large.(Hashable).HashKey() == (&Integer{Value: 1}).HashKey() {
+		t.Errorf("big and small integers have same hash keys")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2.0"},
+		{-2.5, "-2.5"},
+		{0.1, "0.1"},
+		{1e-9, "1e-09"},
+		{123456789, "123456789.0"},
+		{1e21, "1e+21"},
+		{0, "0.0"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if actual := (&Float{Value: tt.value}).Inspect(); actual != tt.expected {
+			t.Errorf("Expected %s but %s", tt.expected, actual)
+		}
+	}
+}
@@ -1,50 +1,96 @@
 package parser
 
 import (
+	"fmt"
 	"github.com/moreal/monkey/ast"
 	"github.com/moreal/monkey/lexer"
 	"github.com/moreal/monkey/token"
+	"math/big"
 	"strconv"
 )
 
 const (
 	_ int = iota
 	LOWEST
-	EQUALS
-	LESSGREATER
 	LOGICALOR
 	LOGICALAND
+	EQUALS
+	LESSGREATER
+	BITOR
+	BITXOR
+	BITAND
+	SHIFT
 	SUM
 	PRODUCT
 	PREFIX
+	POWER
 	CALL
-)
-
-type (
-	prefixParseFn func() ast.Expression
-	infixParseFn  func(ast.Expression) ast.Expression
+	INDEX
 )
 
 var precedences = map[token.TokenType]int{
+	token.LOR:      LOGICALOR,
+	token.LAND:     LOGICALAND,
 	token.EQ:       EQUALS,
 	token.NEQ:      EQUALS,
 	token.LT:       LESSGREATER,
 	token.LTE:      LESSGREATER,
 	token.GT:       LESSGREATER,
 	token.GTE:      LESSGREATER,
-	token.LAND:     LOGICALAND,
-	token.LOR:      LOGICALOR,
+	token.BOR:      BITOR,
+	token.CARET:    BITXOR,
+	token.BAND:     BITAND,
+	token.SHL:      SHIFT,
+	token.SHR:      SHIFT,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT:  PRODUCT,
+	token.POWER:    POWER,
 	token.LPAREN:   CALL,
+	token.LBRACKET: INDEX,
+}
+
+// rightAssociative are the operators grouping from the right, so that
+// 2 ** 3 ** 2 is 2 ** (3 ** 2).
+var rightAssociative = map[token.TokenType]bool{
+	token.POWER: true,
+}
+
+// MaxNestingDepth bounds how deeply expressions may be nested, counting
+// each operand of an operator chain as one level, so that programs cannot
+// exhaust the host stack while they are parsed or compiled.
+const MaxNestingDepth = 10000
+
+type (
+	prefixParseFn func() ast.Expression
+	infixParseFn  func(ast.Expression) ast.Expression
+)
+
+// ParseError describes a syntax error found at Pos, inside Token. Expected
+// is only set when a specific token was required at Token's place.
+type ParseError struct {
+	Pos      token.Position
+	Token    token.Token
+	Expected token.TokenType
+	Message  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
 }
 
 type Parser struct {
 	l         *lexer.Lexer
 	curToken  token.Token
 	peekToken token.Token
+	errors    []*ParseError
+
+	// depth is the nesting depth of the expression being parsed. Parsing
+	// stops at the end of the input once it exceeds MaxNestingDepth.
+	depth   int
+	tooDeep bool
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -55,8 +101,14 @@ func New(l *lexer.Lexer) *Parser {
 
 	parser.registerPrefixParseFn(token.IDENT, parser.parseIdentifier)
 	parser.registerPrefixParseFn(token.INT, parser.parseIntegerLiteral)
+	parser.registerPrefixParseFn(token.FLOAT, parser.parseFloatLiteral)
+	parser.registerPrefixParseFn(token.STRING, parser.parseStringLiteral)
 
 	parser.registerPrefixParseFn(token.LPAREN, parser.parseGroupedExpression)
+	parser.registerPrefixParseFn(token.LBRACKET, parser.parseArrayLiteral)
+	// Blocks are only parsed after if, else and fn, so a brace in prefix
+	// position always opens a hash literal.
+	parser.registerPrefixParseFn(token.LBRACE, parser.parseHashLiteral)
 
 	parser.registerPrefixParseFn(token.IF, parser.parseIfExpression)
 
@@ -64,9 +116,11 @@ func New(l *lexer.Lexer) *Parser {
 
 	parser.registerPrefixParseFn(token.TRUE, parser.parseBoolean)
 	parser.registerPrefixParseFn(token.FALSE, parser.parseBoolean)
+	parser.registerPrefixParseFn(token.NULL, parser.parseNull)
 
 	parser.registerPrefixParseFn(token.MINUS, parser.parsePrefixExpression)
 	parser.registerPrefixParseFn(token.BANG, parser.parsePrefixExpression)
+	parser.registerPrefixParseFn(token.TILDE, parser.parsePrefixExpression)
 
 	parser.registerInfixParseFn(token.PLUS, parser.parseInfixExpression)
 	parser.registerInfixParseFn(token.MINUS, parser.parseInfixExpression)
@@ -80,14 +134,28 @@ func New(l *lexer.Lexer) *Parser {
 	parser.registerInfixParseFn(token.NEQ, parser.parseInfixExpression)
 	parser.registerInfixParseFn(token.LAND, parser.parseInfixExpression)
 	parser.registerInfixParseFn(token.LOR, parser.parseInfixExpression)
+	parser.registerInfixParseFn(token.PERCENT, parser.parseInfixExpression)
+	parser.registerInfixParseFn(token.POWER, parser.parseInfixExpression)
+	parser.registerInfixParseFn(token.BAND, parser.parseInfixExpression)
+	parser.registerInfixParseFn(token.BOR, parser.parseInfixExpression)
+	parser.registerInfixParseFn(token.CARET, parser.parseInfixExpression)
+	parser.registerInfixParseFn(token.SHL, parser.parseInfixExpression)
+	parser.registerInfixParseFn(token.SHR, parser.parseInfixExpression)
 
 	parser.registerInfixParseFn(token.LPAREN, parser.parseCallExpression)
+	parser.registerInfixParseFn(token.LBRACKET, parser.parseIndexExpression)
 
 	parser.nextToken()
 	parser.nextToken()
 	return parser
 }
 
+// Errors returns the syntax errors found by ParseProgram in source order.
+// A program is only complete when there are no errors.
+func (p *Parser) Errors() []*ParseError {
+	return p.errors
+}
+
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{Statements: []ast.Statement{}}
 
@@ -102,14 +170,40 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
+// parseStatement returns nil when the statement has any syntax error, so
+// that half-built statements never reach the AST, and for an empty
+// statement, a lone semicolon.
 func (p *Parser) parseStatement() ast.Statement {
+	errorCount := len(p.errors)
+
+	var stmt ast.Statement
 	switch p.curToken.Type {
+	case token.SEMICOLON:
+		return nil
 	case token.LET:
-		return p.parseLetStatement()
+		stmt = p.parseLetStatement()
 	case token.RETURN:
-		return p.parseReturnStatement()
+		stmt = p.parseReturnStatement()
 	default:
-		return p.parseExpressionStatement()
+		stmt = p.parseExpressionStatement()
+	}
+
+	if len(p.errors) == errorCount {
+		p.endStatement()
+	}
+	if len(p.errors) > errorCount {
+		p.skipStatement()
+		return nil
+	}
+
+	return stmt
+}
+
+// skipStatement advances to the end of the current statement to avoid
+// reporting errors caused by a previous one.
+func (p *Parser) skipStatement() {
+	for p.curToken.Type != token.SEMICOLON && p.curToken.Type != token.EOF {
+		p.nextToken()
 	}
 }
 
@@ -130,8 +224,6 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	letStmt.Value = p.parseExpression(LOWEST)
 
-	p.nextToken()
-
 	return letStmt
 }
 
@@ -142,10 +234,6 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
-	for p.curToken.Type != token.SEMICOLON {
-		p.nextToken()
-	}
-
 	return stmt
 }
 
@@ -153,22 +241,44 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 	stmt.Expression = p.parseExpression(LOWEST)
 
-	// TODO: Parse expression
+	return stmt
+}
 
-	if p.peekToken.Type == token.SEMICOLON {
+// endStatement moves past the semicolon following the statement ending
+// with the current token, if any. Statements end with their last
+// expression, and must be followed by a semicolon, a line break, a closing
+// brace or the end of the input. A semicolon is only needed between
+// statements on the same line, or to end a statement whose next one would
+// otherwise continue its expression.
+func (p *Parser) endStatement() {
+	switch {
+	case p.peekToken.Type == token.SEMICOLON:
 		p.nextToken()
+	case p.peekToken.Type == token.RBRACE || p.peekToken.Type == token.EOF:
+	case p.peekToken.Start.Line > p.curToken.End.Line:
+	case p.peekToken.Type == token.ILLEGAL:
+		p.illegalTokenError(p.peekToken)
+	default:
+		p.addError(p.peekToken, "expected ; or a new line after statement, got %s instead", p.peekToken.Type)
 	}
-
-	return stmt
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	defer func(depth int) { p.depth = depth }(p.depth)
+	if p.enter() {
+		return nil
+	}
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
+		p.noPrefixParseFnError(p.curToken)
 		return nil
 	}
 
 	leftExp := prefix()
+	if leftExp == nil {
+		return nil
+	}
 
 	for p.peekToken.Type != token.SEMICOLON && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
@@ -177,6 +287,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		}
 
 		p.nextToken()
+		if p.enter() {
+			return nil
+		}
 
 		leftExp = infix(leftExp)
 	}
@@ -184,6 +297,23 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
+// enter increases the nesting depth. When it gets too deep, enter reports
+// it, skips the rest of the input and returns true.
+func (p *Parser) enter() bool {
+	p.depth++
+	if p.depth <= MaxNestingDepth {
+		return false
+	}
+
+	p.addError(p.curToken, "expression nested too deeply")
+	p.tooDeep = true
+	for p.curToken.Type != token.EOF {
+		p.nextToken()
+	}
+
+	return true
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	expression := &ast.PrefixExpression{
 		Token:    p.curToken,
@@ -204,6 +334,9 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.curPrecedence()
+	if rightAssociative[p.curToken.Type] {
+		precedence--
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 
@@ -274,60 +407,218 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return expr
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	var parameters []*ast.Identifier
+func (p *Parser) parseFunctionParameters() []*ast.Parameter {
+	var parameters []*ast.Parameter
 
 	if p.peekToken.Type == token.RPAREN {
 		p.nextToken()
 		return parameters
 	}
 
-	p.nextToken()
-	param := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	parameters = append(parameters, param)
-
-	for p.peekToken.Type == token.COMMA {
-		p.nextToken() // skip ident
-		p.nextToken() // skip comma
-		param := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		parameters = append(parameters, param)
+	for {
+		if param := p.parseFunctionParameter(); param != nil {
+			parameters = append(parameters, param)
+		}
+		if p.peekToken.Type != token.COMMA {
+			break
+		}
+		p.nextToken()
 	}
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
+	p.checkFunctionParameters(parameters)
+
 	return parameters
 }
 
+// parseFunctionParameter parses the parameter after the current token,
+// "name", "name = default" or "...name", leaving the current token at its
+// end. A parameter which is not named by an identifier is reported and
+// skipped, returning nil.
+func (p *Parser) parseFunctionParameter() *ast.Parameter {
+	param := &ast.Parameter{Token: p.peekToken}
+
+	if p.peekToken.Type == token.ELLIPSIS {
+		param.Rest = true
+		p.nextToken()
+	}
+
+	if p.peekToken.Type != token.IDENT {
+		p.peekError(token.IDENT)
+		if p.peekToken.Type != token.COMMA && p.peekToken.Type != token.RPAREN {
+			p.nextToken()
+		}
+		return nil
+	}
+
+	p.nextToken()
+	param.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekToken.Type == token.ASSIGN {
+		p.nextToken()
+		p.nextToken()
+		param.Default = p.parseExpression(LOWEST)
+	}
+
+	return param
+}
+
+// checkFunctionParameters reports parameters which would make the
+// arguments ambiguous: names must be distinct, a rest parameter must come
+// last, and optional parameters after the required ones.
+func (p *Parser) checkFunctionParameters(parameters []*ast.Parameter) {
+	names := make(map[string]bool)
+	optional := false
+	for i, param := range parameters {
+		switch {
+		case names[param.Name.Value]:
+			p.addError(param.Name.Token, "duplicate parameter %s", param.Name)
+		case param.Rest && param.Default != nil:
+			p.addError(param.Token, "rest parameter %s cannot have a default value", param.Name)
+		case param.Rest && i != len(parameters)-1:
+			p.addError(param.Token, "rest parameter %s must be the last parameter", param.Name)
+		case param.Default != nil:
+			optional = true
+		case optional && !param.Rest:
+			p.addError(param.Token, "parameter %s without a default value follows an optional parameter", param.Name)
+		}
+		names[param.Name.Value] = true
+	}
+}
+
+// parseCallExpression parses the arguments of a call. Named arguments,
+// written name: value, must follow the positional ones and name distinct
+// parameters.
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	expr := &ast.CallExpression{Token: p.curToken, Function: function}
-	expr.Arguments = p.parseCallArguments()
+
+	if p.peekToken.Type == token.RPAREN {
+		p.nextToken()
+		expr.Rparen = p.curToken
+		return expr
+	}
+
+	p.nextToken()
+	p.parseCallArgument(expr)
+
+	for p.peekToken.Type == token.COMMA {
+		p.nextToken()
+		p.nextToken()
+		p.parseCallArgument(expr)
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	expr.Rparen = p.curToken
+
 	return expr
 }
 
-func (p *Parser) parseCallArguments() []ast.Expression {
-	var arguments []ast.Expression
+// parseCallArgument parses the argument starting at the current token and
+// adds it to call.
+func (p *Parser) parseCallArgument(call *ast.CallExpression) {
+	if p.curToken.Type != token.IDENT || p.peekToken.Type != token.COLON {
+		if len(call.NamedArguments) > 0 {
+			p.addError(p.curToken, "positional argument follows named arguments")
+		}
+		call.Arguments = append(call.Arguments, p.parseExpression(LOWEST))
+		return
+	}
 
-	if p.peekToken.Type == token.RPAREN {
+	arg := &ast.NamedArgument{Token: p.curToken}
+	arg.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	for _, other := range call.NamedArguments {
+		if other.Name.Value == arg.Name.Value {
+			p.addError(p.curToken, "duplicate argument %s", arg.Name)
+			break
+		}
+	}
+
+	p.nextToken()
+	p.nextToken()
+	arg.Value = p.parseExpression(LOWEST)
+
+	call.NamedArguments = append(call.NamedArguments, arg)
+}
+
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{Token: p.curToken}
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken
+	return array
+}
+
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.curToken}
+
+	for p.peekToken.Type != token.RBRACE {
 		p.nextToken()
-		return arguments
+		key := p.parseExpression(LOWEST)
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
+
+		if p.peekToken.Type != token.RBRACE && !p.expectPeek(token.COMMA) {
+			return nil
+		}
 	}
 
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	hash.Rbrace = p.curToken
+
+	return hash
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	expr := &ast.IndexExpression{Token: p.curToken, Left: left}
+
 	p.nextToken()
-	arguments = append(arguments, p.parseExpression(LOWEST))
+	expr.Index = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	expr.Rbracket = p.curToken
+
+	return expr
+}
+
+// parseExpressionList parses comma separated expressions up to the end
+// token, leaving it as the current token.
+func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+	var list []ast.Expression
+
+	if p.peekToken.Type == end {
+		p.nextToken()
+		return list
+	}
+
+	p.nextToken()
+	list = append(list, p.parseExpression(LOWEST))
 
 	for p.peekToken.Type == token.COMMA {
-		p.nextToken() // skip last argument token
+		p.nextToken() // skip last expression token
 		p.nextToken() // skip comma
-		arguments = append(arguments, p.parseExpression(LOWEST))
+		list = append(list, p.parseExpression(LOWEST))
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(end) {
 		return nil
 	}
 
-	return arguments
+	return list
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
@@ -342,6 +633,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	blockStmt.Rbrace = p.curToken
 
 	return blockStmt
 }
@@ -354,30 +646,110 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	integerLiteral := &ast.IntegerLiteral{Token: p.curToken}
 
 	v, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
-	if err != nil {
+	if err == nil {
+		integerLiteral.Value = v
+		return integerLiteral
+	}
+
+	value, ok := new(big.Int).SetString(p.curToken.Literal, 0)
+	if !ok {
+		p.addError(p.curToken, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 
-	integerLiteral.Value = v
+	integerLiteral.Big = value
 	return integerLiteral
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	v, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(p.curToken, "could not parse %q as float", p.curToken.Literal)
+		return nil
+	}
+
+	return &ast.FloatLiteral{Token: p.curToken, Value: v}
+}
+
+func (p *Parser) parseStringLiteral() ast.Expression {
+	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curToken.Type == token.TRUE}
 }
 
+func (p *Parser) parseNull() ast.Expression {
+	return &ast.Null{Token: p.curToken}
+}
+
 func (p *Parser) expectPeek(tokenType token.TokenType) bool {
 	if p.peekToken.Type == tokenType {
 		p.nextToken()
 		return true
 	} else {
+		p.peekError(tokenType)
 		return false
 	}
 }
 
+func (p *Parser) peekError(tokenType token.TokenType) {
+	if p.peekToken.Type == token.ILLEGAL {
+		p.illegalTokenError(p.peekToken)
+		return
+	}
+
+	p.appendError(&ParseError{
+		Pos:      p.peekToken.Start,
+		Token:    p.peekToken,
+		Expected: tokenType,
+		Message:  fmt.Sprintf("expected next token to be %s, got %s instead", tokenType, p.peekToken.Type),
+	})
+}
+
+func (p *Parser) noPrefixParseFnError(tok token.Token) {
+	if tok.Type == token.ILLEGAL {
+		p.illegalTokenError(tok)
+		return
+	}
+
+	p.addError(tok, "no prefix parse function for %s found", tok.Type)
+}
+
+// illegalTokenError reports the lexical errors which produced tok.
+func (p *Parser) illegalTokenError(tok token.Token) {
+	reported := false
+	for _, err := range p.l.Errors() {
+		if tok.Start.Offset <= err.Pos.Offset && err.Pos.Offset < tok.End.Offset {
+			p.appendError(&ParseError{Pos: err.Pos, Token: tok, Message: err.Message})
+			reported = true
+		}
+	}
+
+	if !reported {
+		p.addError(tok, "illegal token %q", tok.Literal)
+	}
+}
+
+func (p *Parser) addError(tok token.Token, format string, args ...interface{}) {
+	p.appendError(&ParseError{Pos: tok.Start, Token: tok, Message: fmt.Sprintf(format, args...)})
+}
+
+// appendError records err unless parsing was abandoned for being nested
+// too deeply, which leaves every enclosing construct unterminated.
+func (p *Parser) appendError(err *ParseError) {
+	if !p.tooDeep {
+		p.errors = append(p.errors, err)
+	}
+}
+
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	// Comments kept by the lexer for other tools have no meaning here.
+	for p.peekToken.Type == token.COMMENT {
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"github.com/moreal/monkey/ast"
 	"github.com/moreal/monkey/lexer"
+	"github.com/moreal/monkey/token"
 	"log"
+	"math"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLetStatement(t *testing.T) {
@@ -18,6 +22,7 @@ let foobar = 838383;
 	p := New(l)
 
 	program := p.ParseProgram()
+	checkParserErrors(t, p)
 	if program == nil {
 		t.Fatalf("ParseProgram() returned nil.")
 	}
@@ -43,6 +48,31 @@ let foobar = 838383;
 	}
 }
 
+func TestComments(t *testing.T) {
+	input := `// Adds one.
+let inc = fn(x) { x + /* the increment */ 1 }; // trailing
+inc(1)`
+
+	for _, keep := range []bool{false, true} {
+		l := lexer.New(input)
+		l.KeepComments(keep)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		expected := []string{"let inc = fn(x) (x + 1);", "inc(1)"}
+		if len(program.Statements) != len(expected) {
+			t.Fatalf("It should have %d statements but %d", len(expected), len(program.Statements))
+		}
+		for i, stmt := range program.Statements {
+			if stmt.String() != expected[i] {
+				t.Fatalf("Expected %q but %q", expected[i], stmt.String())
+			}
+		}
+	}
+}
+
 func TestReturnStatement(t *testing.T) {
 	input := `
 return 5;
@@ -61,6 +91,7 @@ return 100;
 	p := New(l)
 
 	program := p.ParseProgram()
+	checkParserErrors(t, p)
 	if program == nil {
 		t.Fatalf("ParseProgram() returned nil.")
 	}
@@ -77,6 +108,41 @@ return 100;
 	}
 }
 
+func TestOptionalSemicolons(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"return 5", []string{"return 5;"}},
+		{"let x = 5", []string{"let x = 5;"}},
+		{"let x = 5\nlet y = x\ny", []string{"let x = 5;", "let y = x;", "y"}},
+		{"let x = 5; let y = 6", []string{"let x = 5;", "let y = 6;"}},
+		{"let f = fn() {\n  1\n}\nf()", []string{"let f = fn() 1;", "f()"}},
+		{"return 1\nreturn 2;", []string{"return 1;", "return 2;"}},
+		{"let x = 1;; x", []string{"let x = 1;", "x"}},
+		{"fn() { return 1 }", []string{"fn() return 1;"}},
+		{"if (x) { let y = 1 } else { return 2 }", []string{"ifx let y = 1;else return 2;"}},
+		{"let f = fn() { 1 }\nf()", []string{"let f = fn() 1;", "f()"}},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != len(test.expected) {
+			t.Fatalf("Expected %d statements from %q but %d", len(test.expected), test.input, len(program.Statements))
+		}
+		for i, stmt := range program.Statements {
+			if stmt.String() != test.expected[i] {
+				t.Errorf("Expected %q but %q", test.expected[i], stmt.String())
+			}
+		}
+	}
+}
+
 func TestIdentifierExpression(t *testing.T) {
 	input := `
 foobar;
@@ -85,6 +151,7 @@ foobar;
 	p := New(l)
 
 	program := p.ParseProgram()
+	checkParserErrors(t, p)
 	if program == nil {
 		t.Fatalf("ParseProgram() returned nil.")
 	}
@@ -109,6 +176,7 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	p := New(l)
 
 	program := p.ParseProgram()
+	checkParserErrors(t, p)
 	if program == nil {
 		t.Fatalf("ParseProgram() returned nil.")
 	}
@@ -125,6 +193,269 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	testIntegerLiteral(t, stmt.Expression, 156497)
 }
 
+func TestPrefixedIntegerLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xFF", 255},
+		{"0XdeadBEEF", 3735928559},
+		{"0x_7fff_ffff_ffff_ffff", math.MaxInt64},
+		{"0o755", 493},
+		{"0755", 493},
+		{"0b1010_0101", 165},
+		{"1_000_000", 1000000},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		literal, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("Expected IntegerLiteral but '%T'", program.Statements[0].(*ast.ExpressionStatement).Expression)
+		}
+		if literal.Value != tt.expected || literal.Big != nil {
+			t.Errorf("Expected %s to be %d but %d", tt.input, tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807", ""},
+		{"9223372036854775808", "9223372036854775808"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"0x1_0000_0000_0000_0000", "18446744073709551616"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		literal, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("Expected IntegerLiteral but '%T'", program.Statements[0].(*ast.ExpressionStatement).Expression)
+		}
+
+		if tt.expected == "" {
+			if literal.Big != nil {
+				t.Errorf("Expected %s to fit in Value but got Big %s", tt.input, literal.Big)
+			}
+			continue
+		}
+
+		if literal.Big == nil || literal.Big.String() != tt.expected {
+			t.Errorf("Expected Big %s but %v", tt.expected, literal.Big)
+		}
+		if literal.String() != tt.expected {
+			t.Errorf("Expected String() %s but %s", tt.expected, literal.String())
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		literal, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("Expected FloatLiteral but '%T'", program.Statements[0].(*ast.ExpressionStatement).Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("Expected %g but %g", tt.expected, literal.Value)
+		}
+		if literal.String() != tt.input {
+			t.Errorf("Expected String() %s but %s", tt.input, literal.String())
+		}
+	}
+}
+
+func TestStringLiteralExpression(t *testing.T) {
+	input := `"hello\tworld";`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("It should have 1 statesments but %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Expected ExpressionStatement but '%T'", program.Statements[0])
+	}
+
+	literal, ok := stmt.Expression.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("Expected StringLiteral but '%T'", stmt.Expression)
+	}
+
+	if literal.Value != "hello\tworld" {
+		t.Fatalf("Expected %q but %q", "hello\tworld", literal.Value)
+	}
+
+	if literal.String() != `"hello\tworld"` {
+		t.Fatalf("Expected '%s' but '%s'", `"hello\tworld"`, literal.String())
+	}
+}
+
+func TestArrayLiteral(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Expected ExpressionStatement but '%T'", program.Statements[0])
+	}
+
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("Expected ArrayLiteral but '%T'", stmt.Expression)
+	}
+
+	if len(array.Elements) != 3 {
+		t.Fatalf("Expected 3 elements but %d", len(array.Elements))
+	}
+
+	testIntegerLiteral(t, array.Elements[0], 1)
+	testInfixExpression(t, array.Elements[1], 2, "*", 2)
+	testInfixExpression(t, array.Elements[2], 3, "+", 3)
+}
+
+func TestEmptyArrayLiteral(t *testing.T) {
+	l := lexer.New("[]")
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("Expected ArrayLiteral but '%T'", stmt.Expression)
+	}
+
+	if len(array.Elements) != 0 {
+		t.Fatalf("Expected no elements but %d", len(array.Elements))
+	}
+}
+
+func TestHashLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected map[string]int64
+	}{
+		{"{}", map[string]int64{}},
+		{`{"one": 1, "two": 2, "three": 3}`, map[string]int64{"one": 1, "two": 2, "three": 3}},
+		{`{"one": 1, "two": 2,}`, map[string]int64{"one": 1, "two": 2}},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		hash, ok := stmt.Expression.(*ast.HashLiteral)
+		if !ok {
+			t.Fatalf("Expected HashLiteral but '%T'", stmt.Expression)
+		}
+
+		if len(hash.Pairs) != len(test.expected) {
+			t.Fatalf("Expected %d pairs but %d", len(test.expected), len(hash.Pairs))
+		}
+
+		for _, pair := range hash.Pairs {
+			key, ok := pair.Key.(*ast.StringLiteral)
+			if !ok {
+				t.Fatalf("Expected StringLiteral key but '%T'", pair.Key)
+			}
+
+			testIntegerLiteral(t, pair.Value, test.expected[key.Value])
+		}
+	}
+}
+
+func TestHashLiteralWithExpressions(t *testing.T) {
+	input := `{"one": 0 + 1, true: 10 - 8, 3: 15 / 5}`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("Expected HashLiteral but '%T'", stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("Expected 3 pairs but %d", len(hash.Pairs))
+	}
+
+	if hash.String() != `{"one": (0 + 1), true: (10 - 8), 3: (15 / 5)}` {
+		t.Fatalf("Unexpected String() '%s'", hash.String())
+	}
+
+	testInfixExpression(t, hash.Pairs[0].Value, 0, "+", 1)
+	testBoolean(t, hash.Pairs[1].Key, true)
+	testInfixExpression(t, hash.Pairs[1].Value, 10, "-", 8)
+	testIntegerLiteral(t, hash.Pairs[2].Key, 3)
+	testInfixExpression(t, hash.Pairs[2].Value, 15, "/", 5)
+}
+
+func TestIndexExpression(t *testing.T) {
+	input := "myArray[1 + 1]"
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Expected ExpressionStatement but '%T'", program.Statements[0])
+	}
+
+	expr, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("Expected IndexExpression but '%T'", stmt.Expression)
+	}
+
+	testIdentifier(t, expr.Left, "myArray")
+	testInfixExpression(t, expr.Index, 1, "+", 1)
+}
+
 func TestCallExpression(t *testing.T) {
 	input := `add(1, 2 * 3, 4 + 5);`
 
@@ -132,6 +463,7 @@ func TestCallExpression(t *testing.T) {
 	p := New(l)
 
 	program := p.ParseProgram()
+	checkParserErrors(t, p)
 	if program == nil {
 		t.Fatalf("ParseProgram() returned nil.")
 	}
@@ -161,6 +493,38 @@ func TestCallExpression(t *testing.T) {
 	testInfixExpression(t, expr.Arguments[2], 4, "+", 5)
 }
 
+func TestCallExpressionNamedArguments(t *testing.T) {
+	input := `add(1, y: 2 * 3, z: {"a": 1})`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	expr, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("Expected CallExpression but '%T'", stmt.Expression)
+	}
+
+	if len(expr.Arguments) != 1 {
+		t.Fatalf("Expected 1 positional argument but %d", len(expr.Arguments))
+	}
+	testLiteralExpression(t, expr.Arguments[0], 1)
+
+	if len(expr.NamedArguments) != 2 {
+		t.Fatalf("Expected 2 named arguments but %d", len(expr.NamedArguments))
+	}
+	testIdentifier(t, expr.NamedArguments[0].Name, "y")
+	testInfixExpression(t, expr.NamedArguments[0].Value, 2, "*", 3)
+	testIdentifier(t, expr.NamedArguments[1].Name, "z")
+
+	if expected := `add(1, y: (2 * 3), z: {"a": 1})`; expr.String() != expected {
+		t.Errorf("Expected %s but %s", expected, expr.String())
+	}
+}
+
 func TestBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input string
@@ -175,6 +539,7 @@ func TestBooleanExpression(t *testing.T) {
 		p := New(l)
 
 		program := p.ParseProgram()
+		checkParserErrors(t, p)
 		if program == nil {
 			t.Fatalf("ParseProgram() returned nil.")
 		}
@@ -199,6 +564,7 @@ func TestIfExpression(t *testing.T) {
 	p := New(l)
 
 	program := p.ParseProgram()
+	checkParserErrors(t, p)
 	if program == nil {
 		t.Fatalf("ParseProgram() returned nil.")
 	}
@@ -242,6 +608,7 @@ func TestIfElseExpression(t *testing.T) {
 	p := New(l)
 
 	program := p.ParseProgram()
+	checkParserErrors(t, p)
 	if program == nil {
 		t.Fatalf("ParseProgram() returned nil.")
 	}
@@ -292,6 +659,7 @@ func TestFunctionLiteral(t *testing.T) {
 	p := New(l)
 
 	program := p.ParseProgram()
+	checkParserErrors(t, p)
 	if program == nil {
 		t.Fatalf("ParseProgram() returned nil.")
 	}
@@ -314,8 +682,8 @@ func TestFunctionLiteral(t *testing.T) {
 		t.Fatalf("Expected 2 parameters but %d parameters seem existed.", len(expr.Parameters))
 	}
 
-	testIdentifier(t, expr.Parameters[0], "x")
-	testIdentifier(t, expr.Parameters[1], "y")
+	testIdentifier(t, expr.Parameters[0].Name, "x")
+	testIdentifier(t, expr.Parameters[1].Name, "y")
 
 	if len(expr.Body.Statements) != 1 {
 		t.Fatalf("Expected 1 statements but %d statements seem existed.", len(expr.Body.Statements))
@@ -337,6 +705,10 @@ func TestParseFunctionParameters(t *testing.T) {
 		{"fn() {};", []string{}},
 		{"fn(x) {};", []string{"x"}},
 		{"fn(x, y, z) {};", []string{"x", "y", "z"}},
+		{"fn(x, y = 10) {};", []string{"x", "y = 10"}},
+		{"fn(x = 1, y = x * 2) {};", []string{"x = 1", "y = (x * 2)"}},
+		{"fn(...rest) {};", []string{"...rest"}},
+		{"fn(a, b = [], ...rest) {};", []string{"a", "b = []", "...rest"}},
 	}
 
 	for _, test := range tests {
@@ -344,6 +716,7 @@ func TestParseFunctionParameters(t *testing.T) {
 		p := New(l)
 
 		program := p.ParseProgram()
+		checkParserErrors(t, p)
 		stmt := program.Statements[0].(*ast.ExpressionStatement)
 		expr := stmt.Expression.(*ast.FunctionLiteral)
 		if len(expr.Parameters) != len(test.expectedParams) {
@@ -351,7 +724,9 @@ func TestParseFunctionParameters(t *testing.T) {
 		}
 
 		for i, expectedParam := range test.expectedParams {
-			testLiteralExpression(t, expr.Parameters[i], expectedParam)
+			if expr.Parameters[i].String() != expectedParam {
+				t.Errorf("Expected parameter %q but %q", expectedParam, expr.Parameters[i].String())
+			}
 		}
 	}
 }
@@ -368,7 +743,25 @@ func TestOrderPrecedences(t *testing.T) {
 		{"1+1*(1+2);", "(1 + (1 * (1 + 2)))"},
 		{"a + add(b * c) + d;", "((a + add((b * c))) + d)"},
 		{"add(add(x) * c);", "add((add(x) * c))"},
-		{"true || false && true || true == false;", "(((true || (false && true)) || true) == false)"},
+		// || and && bind more loosely than comparisons, as in C and Go.
+		{"true || false && true || true == false;", "((true || (false && true)) || (true == false))"},
+		{"a < b && c >= d", "((a < b) && (c >= d))"},
+		{"a % b * c", "((a % b) * c)"},
+		{"2 ** 3 ** 2", "(2 ** (3 ** 2))"},
+		{"-2 ** 2", "(-(2 ** 2))"},
+		{"2 ** -1", "(2 ** (-1))"},
+		{"a * b ** c", "(a * (b ** c))"},
+		{"1 << 2 + 3", "(1 << (2 + 3))"},
+		{"a >> 1 < b", "((a >> 1) < b)"},
+		{"a | b ^ c & d", "(a | (b ^ (c & d)))"},
+		{"x == null || !null", "((x == null) || (!null))"},
+		{"a & b == c", "((a & b) == c)"},
+		{"a & b << 1", "(a & (b << 1))"},
+		{"~a & b", "((~a) & b)"},
+		{"~~a", "(~(~a))"},
+		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},
+		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},
+		{"-a[0]", "(-(a[0]))"},
 	}
 
 	for _, test := range tests {
@@ -376,6 +769,7 @@ func TestOrderPrecedences(t *testing.T) {
 		p := New(l)
 
 		program := p.ParseProgram()
+		checkParserErrors(t, p)
 		if program == nil {
 			t.Fatalf("ParseProgram() returned nil.")
 		}
@@ -412,6 +806,7 @@ func TestParsePrefixExpression(t *testing.T) {
 		p := New(l)
 
 		program := p.ParseProgram()
+		checkParserErrors(t, p)
 		if program == nil {
 			t.Fatalf("ParseProgram() returned nil.")
 		}
@@ -456,6 +851,7 @@ func TestParseInfixExpression(t *testing.T) {
 		p := New(l)
 
 		program := p.ParseProgram()
+		checkParserErrors(t, p)
 		if program == nil {
 			t.Fatalf("ParseProgram() returned nil.")
 		}
@@ -473,6 +869,223 @@ func TestParseInfixExpression(t *testing.T) {
 	}
 }
 
+func TestParserErrors(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedMessages []string
+	}{
+		{"let = 5;", []string{"expected next token to be IDENT, got = instead"}},
+		{"let x 5;", []string{"expected next token to be =, got INT instead"}},
+		{"let x = ;", []string{"no prefix parse function for ; found"}},
+		{"if (x { x }", []string{"expected next token to be ), got { instead"}},
+		{"fn(x, y { x }", []string{"expected next token to be ), got { instead"}},
+		{"fn(...a, b) { a }", []string{"rest parameter a must be the last parameter"}},
+		{"fn(...a = []) { a }", []string{"rest parameter a cannot have a default value"}},
+		{"fn(a = 1, b) { a }", []string{"parameter b without a default value follows an optional parameter"}},
+		{"fn(a, a) { a }", []string{"duplicate parameter a"}},
+		{"fn(1, true) { 1 }", []string{"expected next token to be IDENT, got INT instead", "expected next token to be IDENT, got TRUE instead"}},
+		{"fn(a = ) { a }", []string{"no prefix parse function for ) found", "expected next token to be ), got { instead"}},
+		{"add(1, 2;", []string{"expected next token to be ), got ; instead"}},
+		{"f(x: 1, 2)", []string{"positional argument follows named arguments"}},
+		{"f(x: 1, y: 2, x: 3)", []string{"duplicate argument x"}},
+		{"f(x: )", []string{"no prefix parse function for ) found", "expected next token to be ), got EOF instead"}},
+		{"@;", []string{"illegal character '@'"}},
+		{"1e400;", []string{`could not parse "1e400" as float`}},
+		{"0x;", []string{"hexadecimal literal has no digits"}},
+		{"let mask = 0b102;", []string{"invalid digit '2' in binary literal"}},
+		{"1__000;", []string{"'_' must separate successive digits"}},
+		{"[1, 2;", []string{"expected next token to be ], got ; instead"}},
+		{"a[1;", []string{"expected next token to be ], got ; instead"}},
+		{`{"a" 1}`, []string{"expected next token to be :, got INT instead"}},
+		{`{"a": 1 "b": 2}`, []string{"expected next token to be ,, got STRING instead"}},
+		{`"abc`, []string{"unterminated string literal"}},
+		{`"a\qb\u{110000}";`, []string{`unknown escape sequence \q`, "invalid Unicode code point U+110000"}},
+		{"1 2 3", []string{"expected ; or a new line after statement, got INT instead"}},
+		{`"a" "b"`, []string{"expected ; or a new line after statement, got STRING instead"}},
+		{"let x = 5 x", []string{"expected ; or a new line after statement, got IDENT instead"}},
+		{"if (x) { 1 } 2", []string{"expected ; or a new line after statement, got INT instead"}},
+		{"let = 5; let y 10;", []string{
+			"expected next token to be IDENT, got = instead",
+			"expected next token to be =, got INT instead",
+		}},
+		{strings.Repeat("(", MaxNestingDepth) + "1" + strings.Repeat(")", MaxNestingDepth), []string{"expression nested too deeply"}},
+		{"1" + strings.Repeat(" + 1", MaxNestingDepth), []string{"expression nested too deeply"}},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		if len(program.Statements) != 0 {
+			t.Errorf("Expected no statements from '%s' but %d", test.input, len(program.Statements))
+		}
+
+		errors := p.Errors()
+		if len(errors) != len(test.expectedMessages) {
+			t.Errorf("Expected %d errors from '%s' but %d: %v", len(test.expectedMessages), test.input, len(errors), errors)
+			continue
+		}
+
+		for i, expectedMessage := range test.expectedMessages {
+			if errors[i].Message != expectedMessage {
+				t.Errorf("Expected error message '%s' but '%s'", expectedMessage, errors[i].Message)
+			}
+		}
+	}
+}
+
+func TestParserErrorExpectedToken(t *testing.T) {
+	l := lexer.New("let x 5;")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) != 1 {
+		t.Fatalf("Expected 1 error but %d", len(p.Errors()))
+	}
+
+	err := p.Errors()[0]
+	if err.Expected != token.ASSIGN {
+		t.Errorf("Expected token '%s' but '%s'", token.ASSIGN, err.Expected)
+	}
+
+	if err.Token.Type != token.INT || err.Token.Literal != "5" {
+		t.Errorf("Expected the error at INT '5' but %s '%s'", err.Token.Type, err.Token.Literal)
+	}
+}
+
+func TestParserErrorPosition(t *testing.T) {
+	l := lexer.NewFile("test.mk", "let x = 1;\nlet = 2;")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) != 1 {
+		t.Fatalf("Expected 1 error but %d", len(p.Errors()))
+	}
+
+	expected := "test.mk:2:5: expected next token to be IDENT, got = instead"
+	if p.Errors()[0].Error() != expected {
+		t.Fatalf("Expected '%s' but '%s'", expected, p.Errors()[0].Error())
+	}
+}
+
+func TestFunctionParameterErrors(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors []string
+	}{
+		{"fn(x, 1) { x }", []string{"1:7: expected next token to be IDENT, got INT instead"}},
+		{"fn(\"x\") { x }", []string{`1:4: expected next token to be IDENT, got STRING instead`}},
+		{"fn(x,) { x }", []string{"1:6: expected next token to be IDENT, got ) instead"}},
+		{"fn(...) { 1 }", []string{"1:7: expected next token to be IDENT, got ) instead"}},
+		{"fn(a, b,\n  a) { a }", []string{"2:3: duplicate parameter a"}},
+		{"fn(a, b = 1, ...a) { a }", []string{"1:17: duplicate parameter a"}},
+		{"fn(a, a, a) { a }", []string{"1:7: duplicate parameter a", "1:10: duplicate parameter a"}},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(test.expectedErrors) {
+			t.Errorf("Expected %d errors from %q but %d: %v", len(test.expectedErrors), test.input, len(errors), errors)
+			continue
+		}
+
+		for i, expected := range test.expectedErrors {
+			if errors[i].Error() != expected {
+				t.Errorf("Expected error '%s' but '%s'", expected, errors[i].Error())
+			}
+		}
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStart string
+		expectedEnd   string
+	}{
+		{"foobar;", "1:1", "1:7"},
+		{"let x = 1 + 2;", "1:1", "1:14"},
+		{"return  -5;", "1:1", "1:11"},
+		{"add(1, 2 * 3)", "1:1", "1:14"},
+		{"add(1, y: 2 * 3)", "1:1", "1:17"},
+		{"if (x) { y } else {\n  z\n}", "1:1", "3:2"},
+		{"fn(x) {\n  x\n};", "1:1", "3:2"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0]
+		if stmt.Pos().String() != test.expectedStart {
+			t.Errorf("Expected '%s' to start at %s but %s", test.input, test.expectedStart, stmt.Pos())
+		}
+		if stmt.End().String() != test.expectedEnd {
+			t.Errorf("Expected '%s' to end at %s but %s", test.input, test.expectedEnd, stmt.End())
+		}
+	}
+}
+
+// FuzzParseProgram checks that the parser terminates on any input, well
+// formed or not, without panicking.
+func FuzzParseProgram(f *testing.F) {
+	seeds := []string{
+		"return 5",
+		"return",
+		"let x = 5",
+		"let x =",
+		"let",
+		"let x = 5 let y = 6 x + y",
+		"if (x) { return 1 } else { return 2 }",
+		"fn(a, b = 1, ...rest) { a }(1, b: 2)",
+		`{"a": [1, 2][0]}["a"]`,
+		"fn(1, true) {",
+		"((((",
+		";;;",
+		"/* unterminated",
+		"\"unterminated",
+		"0x_1 + 1__0",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			New(lexer.New(input)).ParseProgram()
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("parsing %q did not terminate", input)
+		}
+	})
+}
+
+func checkParserErrors(t *testing.T, p *Parser) {
+	errors := p.Errors()
+	if len(errors) == 0 {
+		return
+	}
+
+	t.Errorf("parser has %d errors", len(errors))
+	for _, err := range errors {
+		t.Errorf("parser error: %s", err.Error())
+	}
+	t.FailNow()
+}
+
 func testIdentifier(t *testing.T, expr ast.Expression, name string) {
 	ident, ok := expr.(*ast.Identifier)
 	if !ok {
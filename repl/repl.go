@@ -3,37 +3,210 @@ package repl
 import (
 	"bufio"
 	"fmt"
+	"github.com/moreal/monkey/ast"
+	"github.com/moreal/monkey/compiler"
 	"github.com/moreal/monkey/evaluator"
 	"github.com/moreal/monkey/lexer"
 	"github.com/moreal/monkey/object"
 	"github.com/moreal/monkey/parser"
+	"github.com/moreal/monkey/token"
+	"github.com/moreal/monkey/vm"
 	"io"
+	"strings"
 )
 
-const PROMPT = ">> "
+const (
+	PROMPT              = ">> "
+	CONTINUATION_PROMPT = ".. "
+)
 
-func Start(in io.Reader, out io.Writer, err io.Writer) {
-	scanner := bufio.NewScanner(in)
+// Start reads statements from in and prints their values, and the output
+// of puts, to out. Input is read until it forms complete statements,
+// showing CONTINUATION_PROMPT meanwhile; an empty line evaluates the input
+// as it is. checked selects checked integer arithmetic.
+func Start(in io.Reader, out io.Writer, err io.Writer, checked bool) {
 	env := object.NewEnvironment()
+	env.SetCheckedArithmetic(checked)
+	start(in, out, err, func(program *ast.Program) object.Object {
+		return evaluator.Eval(program, env)
+	})
+}
+
+// StartVM is like Start, but compiles the statements to bytecode and runs
+// them on the VM. Definitions are kept from one input to the next as with
+// Eval.
+func StartVM(in io.Reader, out io.Writer, err io.Writer, checked bool) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, builtin := range evaluator.Builtins() {
+		symbolTable.DefineBuiltin(i, builtin.Name)
+	}
+	var constants []object.Object
+	globals := make([]object.Object, vm.GlobalsSize)
+
+	start(in, out, err, func(program *ast.Program) object.Object {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(program); err != nil {
+			return toError(err)
+		}
+
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		machine := vm.NewWithGlobals(bytecode, globals)
+		machine.SetCheckedArithmetic(checked)
+		if err := machine.Run(); err != nil {
+			return toError(err)
+		}
+		return machine.LastPoppedStackElem()
+	})
+}
+
+// start runs the REPL, evaluating programs with run.
+func start(in io.Reader, out io.Writer, err io.Writer, run func(*ast.Program) object.Object) {
+	defer func(output io.Writer) { evaluator.Output = output }(evaluator.Output)
+	evaluator.Output = out
+
+	scanner := bufio.NewScanner(in)
+
+	var input strings.Builder
 	for {
-		if _, err := fmt.Fprintf(err, PROMPT); err != nil {
+		prompt := PROMPT
+		if input.Len() > 0 {
+			prompt = CONTINUATION_PROMPT
+		}
+		if _, err := fmt.Fprint(err, prompt); err != nil {
 			panic(err)
 		}
 
 		scanned := scanner.Scan()
 		if !scanned {
+			if input.Len() > 0 {
+				evaluate(input.String(), run, out, err)
+			}
 			return
 		}
 
 		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		if input.Len() > 0 {
+			if line == "" {
+				evaluate(input.String(), run, out, err)
+				input.Reset()
+				continue
+			}
+			input.WriteString("\n")
+		}
+		input.WriteString(line)
+
+		if isIncomplete(input.String()) {
+			continue
+		}
 
-		evaluated := evaluator.Eval(p.ParseProgram(), env)
-		if evaluated != nil {
-			if _, err := fmt.Fprintln(out, evaluated.Inspect()); err != nil {
-				panic(err)
+		evaluate(input.String(), run, out, err)
+		input.Reset()
+	}
+}
+
+func evaluate(input string, run func(*ast.Program) object.Object, out io.Writer, err io.Writer) {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(err, p.Errors())
+		return
+	}
+
+	evaluated := run(program)
+	if _, err := fmt.Fprintln(out, evaluated.Inspect()); err != nil {
+		panic(err)
+	}
+}
+
+// continuationTokens are the tokens which cannot end a statement.
+var continuationTokens = map[token.TokenType]bool{
+	token.ASSIGN:   true,
+	token.EQ:       true,
+	token.NEQ:      true,
+	token.LOR:      true,
+	token.LAND:     true,
+	token.BOR:      true,
+	token.BAND:     true,
+	token.CARET:    true,
+	token.TILDE:    true,
+	token.PLUS:     true,
+	token.MINUS:    true,
+	token.BANG:     true,
+	token.ASTERISK: true,
+	token.POWER:    true,
+	token.SLASH:    true,
+	token.PERCENT:  true,
+	token.LT:       true,
+	token.LTE:      true,
+	token.SHL:      true,
+	token.GT:       true,
+	token.GTE:      true,
+	token.SHR:      true,
+	token.COMMA:    true,
+	token.COLON:    true,
+	token.FUNCTION: true,
+	token.LET:      true,
+	token.IF:       true,
+	token.ELSE:     true,
+	token.RETURN:   true,
+}
+
+// isIncomplete reports whether input stops in the middle of a statement:
+// inside brackets or a string literal, or right after an operator. Input
+// ending with the consequence of an if is incomplete too, as an else may
+// follow on the next line.
+func isIncomplete(input string) bool {
+	l := lexer.New(input)
+
+	// open holds whether each open bracket starts the condition or the
+	// consequence of an if.
+	var open []bool
+	var last token.Token
+	ifCondition, ifConsequence := false, false
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		closed := false
+		switch tok.Type {
+		case token.LPAREN:
+			open = append(open, last.Type == token.IF)
+		case token.LBRACE:
+			open = append(open, last.Type == token.RPAREN && ifCondition)
+		case token.LBRACKET:
+			open = append(open, false)
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			if len(open) > 0 {
+				closed = open[len(open)-1]
+				open = open[:len(open)-1]
 			}
 		}
+		ifCondition = tok.Type == token.RPAREN && closed
+		ifConsequence = tok.Type == token.RBRACE && closed
+		last = tok
+	}
+
+	for _, err := range l.Errors() {
+		if err.Unterminated {
+			return true
+		}
+	}
+
+	return len(open) > 0 || ifConsequence || continuationTokens[last.Type]
+}
+
+func toError(err error) *object.Error {
+	if errObj, ok := err.(*object.Error); ok {
+		return errObj
+	}
+	return &object.Error{Message: err.Error()}
+}
+
+func printParserErrors(out io.Writer, errors []*parser.ParseError) {
+	for _, e := range errors {
+		if _, err := fmt.Fprintf(out, "\t%s\n", e.Error()); err != nil {
+			panic(err)
+		}
 	}
 }
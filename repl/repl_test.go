@@ -0,0 +1,97 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestIsIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"", false},
+		{"1 + 2", false},
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\n  x * 2\n}", false},
+		{"add(1,", true},
+		{"add(1,\n2)", false},
+		{"[1, 2", true},
+		{`{"a": 1`, true},
+		{"1 +", true},
+		{"let x =", true},
+		{"true &&", true},
+		{`"abc`, true},
+		{`"abc"`, false},
+		{"/* note", true},
+		{"1 + // note", true},
+		{"1 // note", false},
+		{"if (x) { 1 } else", true},
+		// An else may follow the consequence of an if on the next line.
+		{"if (x) { 1 }", true},
+		{"if (x) { 1 }\nelse { 2 }", false},
+		{"if (f(x)) { if (y) { 1 } }", true},
+		{"fn(x) { if (x) { 1 } }", false},
+		{"let f = fn(x) { 1 }", false},
+		{"if (x) { 1 };", false},
+		{"1 + 2)", false},
+	}
+
+	for _, tt := range tests {
+		if isIncomplete(tt.input) != tt.expected {
+			t.Errorf("Expected isIncomplete(%q) to be %t", tt.input, tt.expected)
+		}
+	}
+}
+
+func TestStartMultiLineInput(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1,
+    2)
+let x = (1
+
+add(x, 1)
+`
+	var out, errOut bytes.Buffer
+	Start(strings.NewReader(input), &out, &errOut, false)
+
+	expected := "fn(a, b) {\n(a + b)\n}\n3\nERROR: 1:5: identifier not found: x\n"
+	if out.String() != expected {
+		t.Fatalf("Expected output %q but %q", expected, out.String())
+	}
+
+	if !strings.Contains(errOut.String(), CONTINUATION_PROMPT) {
+		t.Fatalf("Expected continuation prompts in %q", errOut.String())
+	}
+
+	if !strings.Contains(errOut.String(), "expected next token to be ), got EOF instead") {
+		t.Fatalf("Expected the incomplete input to be reported in %q", errOut.String())
+	}
+}
+
+func TestStartVM(t *testing.T) {
+	input := `let x = 5
+x * 2
+let add = fn(a, b) { a + b }; add(x, 1)
+let x = 10; add(x, 1)
+y
+let f = fn() { g() }; let g = fn() { x }; f()
+puts("hi", 1)
+if (x > 20) { 1 }
+else { 2 }
+`
+	expected := "5\n10\n6\n11\nERROR: 1:1: identifier not found: y\n10\nhi\n1\nnull\n2\n"
+
+	for name, start := range map[string]func(io.Reader, io.Writer, io.Writer, bool){"Start": Start, "StartVM": StartVM} {
+		var out, errOut bytes.Buffer
+		start(strings.NewReader(input), &out, &errOut, false)
+
+		if out.String() != expected {
+			t.Errorf("Expected output %q from %s but %q", expected, name, out.String())
+		}
+	}
+}
@@ -1,10 +1,46 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
+// Position is a location in the source. Line and Column start at 1 and
+// Offset is the byte offset from the beginning of the input.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// IsValid reports whether the position points into a source.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position as "file:line:column", omitting the parts
+// which are unknown.
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// Token is a lexical token. End is the position right after its last
+// character.
 type Token struct {
 	Type    TokenType
 	Literal string
+	Start   Position
+	End     Position
 }
 
 const (
@@ -12,32 +48,46 @@ const (
 	EOF       = "EOF"
 	IDENT     = "IDENT"
 	INT       = "INT"
+	FLOAT     = "FLOAT"
+	STRING    = "STRING"
+	COMMENT   = "COMMENT"
 	ASSIGN    = "="
 	EQ        = "=="
 	NEQ       = "!="
-	BOR       = "||"
+	BOR       = "|"
 	LOR       = "||"
 	BAND      = "&"
 	LAND      = "&&"
+	CARET     = "^"
+	TILDE     = "~"
 	PLUS      = "+"
 	MINUS     = "-"
 	BANG      = "!"
 	ASTERISK  = "*"
+	POWER     = "**"
 	SLASH     = "/"
+	PERCENT   = "%"
 	LT        = "<"
 	LTE       = "<="
+	SHL       = "<<"
 	GT        = ">"
 	GTE       = ">="
+	SHR       = ">>"
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
+	ELLIPSIS  = "..."
 	LPAREN    = "("
 	RPAREN    = ")"
 	LBRACE    = "{"
 	RBRACE    = "}"
+	LBRACKET  = "["
+	RBRACKET  = "]"
 	FUNCTION  = "FUNCTION"
 	LET       = "LET"
 	TRUE      = "TRUE"
 	FALSE     = "FALSE"
+	NULL      = "NULL"
 	IF        = "IF"
 	ELSE      = "ELSE"
 	RETURN    = "RETURN"
@@ -0,0 +1,25 @@
+package vm
+
+import (
+	"github.com/moreal/monkey/code"
+	"github.com/moreal/monkey/object"
+)
+
+// Frame is the execution state of a function call. ip points to the
+// instruction being executed, and the stack of the call starts at
+// basePointer. locals are the slots of the locals on the stack, or on the
+// heap when closures capture them.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+	locals      []object.Object
+}
+
+func NewFrame(cl *object.Closure, basePointer int, locals []object.Object) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer, locals: locals}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,635 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/moreal/monkey/code"
+	"github.com/moreal/monkey/compiler"
+	"github.com/moreal/monkey/evaluator"
+	"github.com/moreal/monkey/object"
+)
+
+const (
+	StackSize   = 65536
+	GlobalsSize = 65536
+	// MaxFrames allows as many nested calls as Eval, besides the frame of
+	// the program itself.
+	MaxFrames = evaluator.MaxCallDepth + 1
+)
+
+// binaryOperators maps the opcodes of infix operators to the operators
+// they apply with evaluator.EvalInfix.
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:                "+",
+	code.OpSub:                "-",
+	code.OpMul:                "*",
+	code.OpDiv:                "/",
+	code.OpEqual:              "==",
+	code.OpNotEqual:           "!=",
+	code.OpLessThan:           "<",
+	code.OpLessThanOrEqual:    "<=",
+	code.OpGreaterThan:        ">",
+	code.OpGreaterThanOrEqual: ">=",
+	code.OpAnd:                "&&",
+	code.OpOr:                 "||",
+	code.OpMod:                "%",
+	code.OpPow:                "**",
+	code.OpBitAnd:             "&",
+	code.OpBitOr:              "|",
+	code.OpBitXor:             "^",
+	code.OpShiftLeft:          "<<",
+	code.OpShiftRight:         ">>",
+}
+
+// VM executes Bytecode with the same semantics as evaluator.Eval.
+type VM struct {
+	constants   []object.Object
+	globalNames []string
+
+	stack []object.Object
+	sp    int // stack[sp-1] is the top of the stack.
+
+	globals []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	checked bool
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobals(bytecode, make([]object.Object, GlobalsSize))
+}
+
+// NewWithGlobals returns a VM running bytecode on globals, which may hold
+// the globals of the programs compiled before it, as in the REPL. globals
+// must have GlobalsSize elements.
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+	}
+
+	mainClosure := &object.Closure{Fn: mainFn}
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = NewFrame(mainClosure, 0, nil)
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globalNames: bytecode.GlobalNames,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		globals: globals,
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// SetCheckedArithmetic makes integer operations fail with an error when
+// their result or an integer literal does not fit in an int64, as
+// object.Environment.SetCheckedArithmetic does for Eval.
+func (vm *VM) SetCheckedArithmetic(checked bool) {
+	vm.checked = checked
+}
+
+// LastPoppedStackElem returns the value of the last statement executed,
+// which is the value of the program once Run returns, or NULL when there
+// was none.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	if vm.stack[vm.sp] == nil {
+		return evaluator.NULL
+	}
+	return vm.stack[vm.sp]
+}
+
+// Run executes the program. Runtime errors are returned as *object.Error
+// located at the expression which raised them.
+func (vm *VM) Run() (err error) {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	defer func() {
+		// As in Eval, a panic is a bug of the VM which must not bring down
+		// the host.
+		if r := recover(); r != nil {
+			err = &object.Error{Message: fmt.Sprintf("internal error: %v", r)}
+		}
+
+		if errObj, ok := err.(*object.Error); ok && !errObj.Pos.IsValid() {
+			errObj.Pos = vm.currentFrame().cl.Fn.Positions[ip]
+		}
+	}()
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			constant := vm.constants[constIndex]
+			if literal, ok := constant.(*object.BigInteger); ok && vm.checked {
+				return evaluator.LiteralOverflow(literal.Value)
+			}
+			if err := vm.push(constant); err != nil {
+				return err
+			}
+		case code.OpPop:
+			vm.pop()
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual,
+			code.OpLessThan, code.OpLessThanOrEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual,
+			code.OpAnd, code.OpOr, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
+			right := vm.pop()
+			left := vm.pop()
+
+			if result, ok := executeIntegerOperation(op, left, right); ok {
+				vm.stack[vm.sp] = result
+				vm.sp++
+				continue
+			}
+
+			if err := vm.pushResult(evaluator.EvalInfix(binaryOperators[op], left, right, vm.checked)); err != nil {
+				return err
+			}
+		case code.OpMinus:
+			if err := vm.pushResult(evaluator.EvalPrefix("-", vm.pop(), vm.checked)); err != nil {
+				return err
+			}
+		case code.OpBang:
+			if err := vm.pushResult(evaluator.EvalPrefix("!", vm.pop(), vm.checked)); err != nil {
+				return err
+			}
+		case code.OpBitNot:
+			if err := vm.pushResult(evaluator.EvalPrefix("~", vm.pop(), vm.checked)); err != nil {
+				return err
+			}
+		case code.OpTrue:
+			if err := vm.push(evaluator.TRUE); err != nil {
+				return err
+			}
+		case code.OpFalse:
+			if err := vm.push(evaluator.FALSE); err != nil {
+				return err
+			}
+		case code.OpNull:
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !evaluator.IsTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpJumpIfFalse, code.OpJumpIfTrue:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if boolean, ok := vm.stack[vm.sp-1].(*object.Boolean); ok && boolean.Value == (op == code.OpJumpIfTrue) {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpJumpIfSet:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			vm.currentFrame().ip += 3
+
+			if vm.currentFrame().locals[localIndex] != nil {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpJumpIfFreeSet:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			vm.currentFrame().ip += 3
+
+			if *vm.currentFrame().cl.Free[freeIndex] != nil {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpJumpIfGlobalSet:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			pos := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			if vm.globals[globalIndex] != nil {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.globals[globalIndex]
+			if value == nil {
+				return &object.Error{Message: fmt.Sprintf("identifier not found: %s", vm.globalNames[globalIndex])}
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			vm.currentFrame().locals[localIndex] = vm.pop()
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			value := frame.locals[localIndex]
+			if value == nil {
+				// The let statement defining the local did not run.
+				return &object.Error{Message: fmt.Sprintf("identifier not found: %s", frame.cl.Fn.LocalNames[localIndex])}
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.push(evaluator.Builtins()[builtinIndex]); err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cl := vm.currentFrame().cl
+			value := *cl.Free[freeIndex]
+			if value == nil {
+				return &object.Error{Message: fmt.Sprintf("identifier not found: %s", cl.Fn.FreeVariables[freeIndex].Name)}
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(&object.Array{Elements: elements}); err != nil {
+				return err
+			}
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash := vm.buildHash(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+		case code.OpHashKey:
+			if key := vm.stack[vm.sp-1]; !isHashable(key) {
+				return &object.Error{Message: fmt.Sprintf("unusable as hash key: %s", key.Type())}
+			}
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.pushResult(evaluator.EvalIndex(left, index)); err != nil {
+				return err
+			}
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+		case code.OpCallNamed:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			namesIndex := code.ReadUint16(ins[ip+2:])
+			vm.currentFrame().ip += 3
+
+			names := vm.constants[namesIndex].(*object.Array)
+			if err := vm.executeNamedCall(int(numArgs), names); err != nil {
+				return err
+			}
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			if vm.framesIndex == 1 {
+				// A return statement in the program itself ends it, leaving
+				// the value as the last popped one.
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown opcode %d", op)
+		}
+	}
+
+	return nil
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return &object.Error{Message: fmt.Sprintf("not a function: %s", callee.Type())}
+	}
+}
+
+// executeNamedCall calls the function below numArgs positional arguments
+// followed by the values of the arguments called names on the stack. The
+// arguments are put in the order of the parameters first, leaving nil for
+// the ones which got none, and then passed as for OpCall.
+func (vm *VM) executeNamedCall(numArgs int, names *object.Array) error {
+	numNamed := len(names.Elements)
+	basePointer := vm.sp - numArgs - numNamed
+
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[basePointer:basePointer+numArgs])
+	named := make([]object.Object, numNamed)
+	copy(named, vm.stack[basePointer+numArgs:vm.sp])
+	nameValues := make([]string, numNamed)
+	for i, name := range names.Elements {
+		nameValues[i] = name.(*object.String).Value
+	}
+
+	var bound []object.Object
+	var err *object.Error
+	switch callee := vm.stack[basePointer-1].(type) {
+	case *object.Closure:
+		min, max := arity(callee.Fn)
+		bound, err = evaluator.BindArguments(callee.Fn.ParameterNames, min, max, args, nameValues, named)
+	case *object.Builtin:
+		bound, err = evaluator.BindBuiltinArguments(callee, args, nameValues, named)
+	default:
+		return vm.executeCall(numArgs + numNamed)
+	}
+	if err != nil {
+		return err
+	}
+
+	if basePointer+len(bound) >= StackSize {
+		return &object.Error{Message: "stack overflow"}
+	}
+	copy(vm.stack[basePointer:], bound)
+	vm.sp = basePointer + len(bound)
+
+	return vm.executeCall(len(bound))
+}
+
+// arity returns the minimum and maximum number of arguments fn accepts, as
+// for evaluator.ArityError.
+func arity(fn *object.CompiledFunction) (min, max int) {
+	if fn.Variadic {
+		return fn.NumRequired, -1
+	}
+	return fn.NumRequired, fn.NumParameters
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	fn := cl.Fn
+	if min, max := arity(fn); numArgs < min || max >= 0 && numArgs > max {
+		return evaluator.ArityError(min, max, numArgs)
+	}
+
+	if vm.framesIndex >= MaxFrames {
+		return &object.Error{Message: "stack overflow"}
+	}
+
+	basePointer := vm.sp - numArgs
+	if basePointer+fn.NumLocals >= StackSize {
+		return &object.Error{Message: "stack overflow"}
+	}
+
+	var rest *object.Array
+	if fn.Variadic {
+		rest = &object.Array{Elements: []object.Object{}}
+		if numArgs > fn.NumParameters {
+			rest.Elements = append(rest.Elements, vm.stack[basePointer+fn.NumParameters:vm.sp]...)
+			numArgs = fn.NumParameters
+		}
+	}
+
+	// Locals may hold values left by previous calls, and parameters which
+	// got no argument must be unset for OpJumpIfSet.
+	locals := vm.stack[basePointer : basePointer+fn.NumLocals]
+	for i := numArgs; i < len(locals); i++ {
+		locals[i] = nil
+	}
+	if rest != nil {
+		locals[fn.NumParameters] = rest
+	}
+	if fn.Captured {
+		locals = append([]object.Object(nil), locals...)
+	}
+
+	vm.pushFrame(NewFrame(cl, basePointer, locals))
+	vm.sp = basePointer + fn.NumLocals
+
+	return nil
+}
+
+// callBuiltin calls builtin with the numArgs arguments on the stack. They
+// are copied, as host functions may keep them.
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+
+	result := builtin.Fn(args...)
+	if result == nil {
+		result = evaluator.NULL
+	}
+	vm.sp = vm.sp - numArgs - 1
+
+	return vm.pushResult(result)
+}
+
+// pushClosure pushes a closure over the function at constIndex, capturing
+// its numFree free variables from the current frame.
+func (vm *VM) pushClosure(constIndex, numFree int) error {
+	function, ok := vm.constants[constIndex].(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", vm.constants[constIndex])
+	}
+
+	frame := vm.currentFrame()
+	free := make([]*object.Object, numFree)
+	for i, variable := range function.FreeVariables[:numFree] {
+		if variable.Local {
+			free[i] = &frame.locals[variable.Index]
+		} else {
+			free[i] = frame.cl.Free[variable.Index]
+		}
+	}
+
+	return vm.push(&object.Closure{Fn: function, Free: free})
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) object.Object {
+	pairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		// OpHashKey checked the key already.
+		pairs[key.(object.Hashable).HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}
+}
+
+func isHashable(obj object.Object) bool {
+	_, ok := obj.(object.Hashable)
+	return ok
+}
+
+// pushResult pushes the result of an operation, or returns it when it is
+// an error.
+func (vm *VM) pushResult(result object.Object) error {
+	if errObj, ok := result.(*object.Error); ok {
+		return errObj
+	}
+
+	return vm.push(result)
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return &object.Error{Message: "stack overflow"}
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// executeIntegerOperation is a fast path for the common operations on
+// int64 integers which cannot fail. It reports false when EvalInfix has to
+// be used instead.
+func executeIntegerOperation(op code.Opcode, left, right object.Object) (object.Object, bool) {
+	l, ok := left.(*object.Integer)
+	if !ok {
+		return nil, false
+	}
+	r, ok := right.(*object.Integer)
+	if !ok {
+		return nil, false
+	}
+
+	switch op {
+	case code.OpAdd:
+		return integerResult(evaluator.IntegerArithmetic("+", l.Value, r.Value))
+	case code.OpSub:
+		return integerResult(evaluator.IntegerArithmetic("-", l.Value, r.Value))
+	case code.OpMul:
+		return integerResult(evaluator.IntegerArithmetic("*", l.Value, r.Value))
+	case code.OpMod:
+		if r.Value == 0 {
+			return nil, false
+		}
+		return integerResult(evaluator.IntegerArithmetic("%", l.Value, r.Value))
+	case code.OpBitAnd:
+		return &object.Integer{Value: l.Value & r.Value}, true
+	case code.OpBitOr:
+		return &object.Integer{Value: l.Value | r.Value}, true
+	case code.OpBitXor:
+		return &object.Integer{Value: l.Value ^ r.Value}, true
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(l.Value == r.Value), true
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(l.Value != r.Value), true
+	case code.OpLessThan:
+		return nativeBoolToBooleanObject(l.Value < r.Value), true
+	case code.OpLessThanOrEqual:
+		return nativeBoolToBooleanObject(l.Value <= r.Value), true
+	case code.OpGreaterThan:
+		return nativeBoolToBooleanObject(l.Value > r.Value), true
+	case code.OpGreaterThanOrEqual:
+		return nativeBoolToBooleanObject(l.Value >= r.Value), true
+	default:
+		return nil, false
+	}
+}
+
+func integerResult(value int64, ok bool) (object.Object, bool) {
+	if !ok {
+		return nil, false
+	}
+	return &object.Integer{Value: value}, true
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return evaluator.TRUE
+	}
+	return evaluator.FALSE
+}
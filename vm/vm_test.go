@@ -0,0 +1,108 @@
+package vm
+
+import (
+	"github.com/moreal/monkey/compiler"
+	"github.com/moreal/monkey/evaluator"
+	"github.com/moreal/monkey/lexer"
+	"github.com/moreal/monkey/object"
+	"github.com/moreal/monkey/parser"
+	"testing"
+)
+
+// Most of the language is covered by the evaluator tests, which run every
+// program through both the tree-walking evaluator and this VM.
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"let a = 5; let b = a * 2; b", "10"},
+		{`"mon" + "key"`, "monkey"},
+		{"if (1 > 2) { 10 } else { 20 }", "20"},
+		{"let f = fn(a, b) { return a - b; }; f(5, 2)", "3"},
+		{"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)", "610"},
+		{"[1, 2, 3][1]", "2"},
+		{`{"a": 1}["a"]`, "1"},
+		{"len(push([1], 2))", "2"},
+	}
+
+	for _, tt := range tests {
+		result, err := run(tt.input)
+		if err != nil {
+			t.Errorf("vm error for %q: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("Expected %q to be %s but %s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+	}{
+		{"let a = 1;\na + true", "type mismatch: INTEGER + BOOLEAN", "2:1"},
+		{"foobar", "identifier not found: foobar", "1:1"},
+		{"fn() {\n  if (false) { let y = 1 }\n  y\n}()", "identifier not found: y", "3:3"},
+		{"let f = fn() { f() }; f()", "stack overflow", "1:16"},
+	}
+
+	for _, tt := range tests {
+		_, err := run(tt.input)
+		errObj, ok := err.(*object.Error)
+		if !ok {
+			t.Errorf("Expected an *object.Error for %q but %T (%v)", tt.input, err, err)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("Expected message %q but %q", tt.expectedMessage, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("Expected error at %s but %s", tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+const fibonacci = `
+let fibonacci = fn(x) {
+	if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) }
+};
+fibonacci(20);
+`
+
+func BenchmarkFibonacciVM(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := run(fibonacci); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFibonacciEvaluator(b *testing.B) {
+	program := parser.New(lexer.New(fibonacci)).ParseProgram()
+
+	for i := 0; i < b.N; i++ {
+		evaluator.Eval(program, object.NewEnvironment())
+	}
+}
+
+func run(input string) (object.Object, error) {
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return nil, err
+	}
+
+	return machine.LastPoppedStackElem(), nil
+}